@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"rest-api/components"
+	"rest-api/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Creates the first admin of a fresh database
+	BOOTSTRAP_ADMIN_EMAIL: email of the admin (required)
+	BOOTSTRAP_ADMIN_PASSWORD: password, only required when the user doesn't exist yet
+	BOOTSTRAP_ADMIN_USERNAME: username of a newly created admin, "admin" by default
+Existing user with the email is promoted instead
+Does nothing once any admin exists, further admins are managed through the admin api
+*/
+func BootstrapAdmin(db *mongo.Database) error{
+	userColl := db.Collection("Users")
+	roleChangeColl := db.Collection("RoleChanges")
+
+	email := os.Getenv("BOOTSTRAP_ADMIN_EMAIL")
+	if email == ""{
+		return errors.New("BOOTSTRAP_ADMIN_EMAIL not set")
+	}
+
+	// Checking whether database is fresh
+	exists, err := models.AdminExists(userColl)
+	if err != nil{
+		return err
+	}
+	if exists{
+		log.Println("Admin already exists, nothing to bootstrap")
+		return nil
+	}
+
+	// Promoting existing user
+	user, err := models.GetUserByEmail(email, userColl)
+	if err == nil{
+		if _, err := models.UpdateUserRole(user.ID, models.ROLEADMIN, userColl); err != nil{
+			return err
+		}
+		log.Println("Promoted", email, "to admin")
+		return recordBootstrap(user.ID, user.GetRole(), roleChangeColl)
+	}
+	if err != mongo.ErrNoDocuments{
+		return err
+	}
+
+	// Creating new admin
+	password := os.Getenv("BOOTSTRAP_ADMIN_PASSWORD")
+	if password == ""{
+		return errors.New("BOOTSTRAP_ADMIN_PASSWORD not set")
+	}
+	username := os.Getenv("BOOTSTRAP_ADMIN_USERNAME")
+	if username == ""{
+		username = "admin"
+	}
+	if err := components.GetPasswordPolicy().Validate(password, email, username); err != nil{
+		return err
+	}
+	hashedPassword, err := components.HashPassword(password)
+	if err != nil{
+		return err
+	}
+	request := models.UserRequest{Username: username, Email: email, Password: hashedPassword}
+	admin := request.ToUserIntermediate(models.ROLEADMIN)
+	admin.Verified = true
+	result, err := admin.AddUser(userColl)
+	if err != nil{
+		return err
+	}
+	log.Println("Created admin", email)
+	return recordBootstrap(result.InsertedID.(primitive.ObjectID).Hex(), models.ROLEUSER, roleChangeColl)
+}
+
+func recordBootstrap(userId string, from models.Role, roleChangeColl *mongo.Collection) error{
+	request := models.RoleChangeRequest{UserId: userId, Role: models.ROLEADMIN, Reason: "bootstrap"}
+	_, err := request.ToRoleChangeIntermediate("bootstrap", from).AddRoleChange(roleChangeColl)
+	return err
+}
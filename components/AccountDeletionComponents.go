@@ -0,0 +1,134 @@
+package components
+
+import (
+	"errors"
+	"log"
+	"os"
+	"rest-api/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+)
+
+const (
+	// Time users have to cancel deletion of their account
+	DEFAULTDELETIONGRACEPERIOD = 30 * 24 * time.Hour
+	// How often accounts past their grace period are looked for
+	ACCOUNTPURGEINTERVAL = time.Hour
+)
+
+/*
+Grace period is read from ACCOUNT_DELETION_GRACE_PERIOD (e.g. 720h),
+a zero period deletes accounts on the next purge
+*/
+func GetDeletionGracePeriod()(time.Duration, error){
+	value := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD")
+	if value == ""{
+		return DEFAULTDELETIONGRACEPERIOD, nil
+	}
+	period, err := time.ParseDuration(value)
+	if err != nil || period < 0{
+		return 0, errors.New("invalid ACCOUNT_DELETION_GRACE_PERIOD")
+	}
+	return period, nil
+}
+
+/*
+Removes accounts whose grace period has ended along with everything they left behind
+Audit events and role changes are kept as the security record of the account
+*/
+type AccountPurger struct{
+	UserColl *mongo.Collection
+	PllColl *mongo.Collection
+	CommentColl *mongo.Collection
+	FollowColl *mongo.Collection
+	RelationColl *mongo.Collection
+	SubscriptionColl *mongo.Collection
+	SessionColl *mongo.Collection
+	RefreshColl *mongo.Collection
+	ApiKeyColl *mongo.Collection
+	TokenColl *mongo.Collection
+	ExternalIdentityColl *mongo.Collection
+	DataExportColl *mongo.Collection
+	DataExportArchives *gridfs.Bucket
+	AuditColl *mongo.Collection
+}
+
+/*
+Purges due accounts every @interval until the process exits
+*/
+func (purger *AccountPurger) Start(interval time.Duration){
+	go func(){
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			purger.PurgeDueAccounts(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+/*
+Accounts failing to purge are logged and retried on the next run,
+the user document goes last so nothing is left unreachable
+*/
+func (purger *AccountPurger) PurgeDueAccounts(now time.Time){
+	userIds, err := models.GetUserIdsDueForDeletion(now, purger.UserColl)
+	if err != nil{
+		log.Println("Unable to find accounts due for deletion:", err.Error())
+		return
+	}
+	for _, userId := range userIds{
+		if err := purger.PurgeUser(userId); err != nil{
+			log.Println("Unable to purge account", userId, ":", err.Error())
+			continue
+		}
+		event := models.AuditEventIntermediate{
+			UserId: userId,
+			Type: models.EVENTACCOUNTDELETION,
+			Outcome: models.OUTCOMESUCCESS,
+			Details: map[string]string{"action": "purge"},
+			CreatedOn: time.Now(),
+		}
+		if _, err := event.AddAuditEvent(purger.AuditColl); err != nil{
+			log.Println("Unable to record audit event", models.EVENTACCOUNTDELETION, "of", userId, ":", err.Error())
+		}
+	}
+}
+
+func (purger *AccountPurger) PurgeUser(userId string) error{
+
+	// Content of the user and their traces on content of others
+	if err := models.DeleteUserPlls(userId, purger.PllColl, purger.CommentColl); err != nil{
+		return err
+	}
+	if err := models.DeleteUserComments(userId, purger.PllColl, purger.CommentColl); err != nil{
+		return err
+	}
+	if err := models.PullUserLikes(userId, purger.PllColl); err != nil{
+		return err
+	}
+	if err := models.DeleteUserGraph(userId, purger.FollowColl, purger.RelationColl, purger.SubscriptionColl); err != nil{
+		return err
+	}
+
+	// Credentials and data exports of the user
+	if err := models.DeleteUserDataExportArchives(userId, purger.DataExportArchives); err != nil{
+		return err
+	}
+	err := models.DeleteUserDocuments(userId,
+		purger.SessionColl,
+		purger.RefreshColl,
+		purger.ApiKeyColl,
+		purger.TokenColl,
+		purger.ExternalIdentityColl,
+		purger.DataExportColl,
+	)
+	if err != nil{
+		return err
+	}
+
+	_, err = models.DeleteUser(userId, purger.UserColl)
+	return err
+}
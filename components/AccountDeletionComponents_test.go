@@ -0,0 +1,136 @@
+package components
+
+import (
+	"rest-api/models"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Collection and filter of a find, update or delete command
+func commandFilter(t *testing.T, started *event.CommandStartedEvent)(string, bson.Raw){
+	t.Helper()
+	collection := started.Command.Lookup(started.CommandName).StringValue()
+	switch started.CommandName{
+	case "find":
+		return collection, started.Command.Lookup("filter").Document()
+	case "update":
+		return collection, started.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q").Document()
+	case "delete":
+		return collection, started.Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q").Document()
+	}
+	t.Fatalf("unexpected command %s", started.CommandName)
+	return "", nil
+}
+
+func testPurger(mt *mtest.T) *AccountPurger{
+	archives, err := models.NewDataExportArchives(mt.DB)
+	if err != nil{
+		mt.Fatal(err)
+	}
+	return &AccountPurger{
+		UserColl: mt.DB.Collection("Users"),
+		PllColl: mt.DB.Collection("Pll"),
+		CommentColl: mt.DB.Collection("Comments"),
+		FollowColl: mt.DB.Collection("Follows"),
+		RelationColl: mt.DB.Collection("UserRelations"),
+		SubscriptionColl: mt.DB.Collection("CategorySubscriptions"),
+		SessionColl: mt.DB.Collection("Sessions"),
+		RefreshColl: mt.DB.Collection("RefreshTokens"),
+		ApiKeyColl: mt.DB.Collection("ApiKeys"),
+		TokenColl: mt.DB.Collection("OneTimeTokens"),
+		ExternalIdentityColl: mt.DB.Collection("ExternalIdentities"),
+		DataExportColl: mt.DB.Collection("DataExports"),
+		DataExportArchives: archives,
+		AuditColl: mt.DB.Collection("AuditEvents"),
+	}
+}
+
+func TestPurgeUserFilters(t *testing.T){
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("everything of the user and nothing else", func(mt *mtest.T){
+		id := primitive.NewObjectID()
+		userId := id.Hex()
+		archiveId := primitive.NewObjectID()
+		deleted := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+		updated := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+
+		expected := []struct{
+			collection string
+			filter bson.M
+		}{
+			// Lessons of the user with comments of others on them
+			{"Pll", bson.M{"userId": userId}},
+			{"Comments", bson.M{"pllId": bson.M{"$in": bson.A{"lesson"}}}},
+			{"Pll", bson.M{"userId": userId}},
+			// Comments of the user on lessons of others
+			{"Comments", bson.M{"userId": userId}},
+			{"Pll", bson.M{"comments": bson.M{"$in": bson.A{"comment"}}}},
+			{"Comments", bson.M{"userId": userId}},
+			{"Pll", bson.M{"likes": userId}},
+			// Graph references users through object ids, in either direction
+			{"Follows", bson.M{"$or": bson.A{bson.M{"followerId": id}, bson.M{"followeeId": id}}}},
+			{"UserRelations", bson.M{"$or": bson.A{bson.M{"userId": id}, bson.M{"targetId": id}}}},
+			{"CategorySubscriptions", bson.M{"userId": id}},
+			// Archives live in GridFS, apart from their export documents
+			{"DataExportArchives.files", bson.M{"metadata.userId": userId}},
+			{"DataExportArchives.files", bson.M{"_id": archiveId}},
+			{"DataExportArchives.chunks", bson.M{"files_id": archiveId}},
+			{"Sessions", bson.M{"userId": userId}},
+			{"RefreshTokens", bson.M{"userId": userId}},
+			{"ApiKeys", bson.M{"userId": userId}},
+			{"OneTimeTokens", bson.M{"userId": userId}},
+			{"ExternalIdentities", bson.M{"userId": userId}},
+			{"DataExports", bson.M{"userId": userId}},
+			{"Users", bson.M{"_id": id}},
+		}
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.Pll", mtest.FirstBatch, bson.D{{Key: "_id", Value: "lesson"}, {Key: "userId", Value: userId}}),
+			deleted, deleted,
+			mtest.CreateCursorResponse(0, "db.Comments", mtest.FirstBatch, bson.D{{Key: "_id", Value: "comment"}, {Key: "userId", Value: userId}}),
+			updated, deleted, updated,
+			deleted, deleted, deleted,
+			mtest.CreateCursorResponse(0, "db.DataExportArchives.files", mtest.FirstBatch, bson.D{{Key: "_id", Value: archiveId}}),
+			deleted, deleted,
+			deleted, deleted, deleted, deleted, deleted, deleted,
+			deleted,
+		)
+		if err := testPurger(mt).PurgeUser(userId); err != nil{
+			mt.Fatal(err)
+		}
+
+		events := mt.GetAllStartedEvents()
+		if len(events) != len(expected){
+			mt.Fatalf("expected %d commands, got %d", len(expected), len(events))
+		}
+		for i, want := range expected{
+			collection, filter := commandFilter(t, events[i])
+			wantFilter, err := bson.Marshal(want.filter)
+			if err != nil{
+				mt.Fatal(err)
+			}
+			if collection != want.collection || filter.String() != bson.Raw(wantFilter).String(){
+				mt.Errorf("command %d: expected %s %s, got %s %s", i, want.collection, bson.Raw(wantFilter), collection, filter)
+			}
+		}
+	})
+
+	mt.Run("only accounts past their grace period", func(mt *mtest.T){
+		now := time.Now()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch))
+		testPurger(mt).PurgeDueAccounts(now)
+
+		collection, filter := commandFilter(t, mt.GetAllStartedEvents()[0])
+		scheduledOn := filter.Lookup("deletionScheduledOn", "$lte").Time()
+		if collection != "Users" || !scheduledOn.Equal(now.Truncate(time.Millisecond)){
+			mt.Fatalf("expected users scheduled until now, got %s %s", collection, filter)
+		}
+	})
+}
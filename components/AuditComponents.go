@@ -0,0 +1,36 @@
+package components
+
+import (
+	"log"
+	"time"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Appends event about @userId to the audit log along with ip and user agent of the request
+Caller is recorded as the actor when they act on someone else's account,
+for impersonated requests the impersonating admin is the actor
+Failing to record is logged, it never fails the request itself
+*/
+func RecordAuditEvent(c *gin.Context, eventType models.AuditEventType, outcome models.AuditOutcome, userId string, details map[string]string, coll *mongo.Collection){
+	event := models.AuditEventIntermediate{
+		UserId: userId,
+		Type: eventType,
+		Outcome: outcome,
+		IP: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Details: details,
+		CreatedOn: time.Now(),
+	}
+	if identity := GetIdentity(c); identity != nil && identity.ActorId != ""{
+		event.ActorId = identity.ActorId
+	}else if actorId := c.GetString(USERIDKEY); actorId != "" && actorId != userId{
+		event.ActorId = actorId
+	}
+	if _, err := event.AddAuditEvent(coll); err != nil{
+		log.Println("Unable to record audit event", eventType, "of", userId, ":", err.Error())
+	}
+}
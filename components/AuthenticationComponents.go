@@ -17,6 +17,13 @@ import(
 
 const(
 	USERIDKEY string = "UserId"
+	SESSIONIDKEY string = "SessionId"
+)
+
+const(
+	// Access tokens are short lived, refresh tokens are used to get new ones
+	ACCESSTOKENEXPIRY = time.Minute*15
+	REFRESHTOKENEXPIRY = time.Hour*24*30
 )
 
 // Parses authorization token from request and construct necessary error message
@@ -33,6 +40,38 @@ func GetBearerToken(c *gin.Context) (string, error){
 	return token, nil
 }
 
+// Prefix of api keys, also tells them apart from JWT tokens
+const APIKEYPREFIX = "llk_"
+
+/*
+Retrieves api key from "X-API-Key" header
+or from authorization header when the bearer token is an api key
+*/
+func GetApiKey(c *gin.Context) string{
+	if apiKey := c.Request.Header.Get("X-API-Key"); apiKey != ""{
+		return apiKey
+	}
+	if token, err := GetBearerToken(c); err == nil && strings.HasPrefix(token, APIKEYPREFIX){
+		return token
+	}
+	return ""
+}
+
+/*
+Response body for suspended or banned users
+Suspended users are told when they can use their account again
+*/
+func AccountRestrictedResponse(err *models.AccountRestrictedError) gin.H{
+	response := gin.H{"message": err.Error(), "banned": err.Banned}
+	if !err.Banned{
+		response["suspendedUntil"] = err.Until
+	}
+	if err.Reason != ""{
+		response["reason"] = err.Reason
+	}
+	return response
+}
+
 // Retreives JWT Secret from environment variable
 func GetJWTSecret() ([]byte, error){
 	secret := os.Getenv("JWT_SECRET")
@@ -43,23 +82,157 @@ func GetJWTSecret() ([]byte, error){
 }
 
 
+// Retreives issuer of JWT tokens from environment variable
+func GetJWTIssuer() string{
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != ""{
+		return issuer
+	}
+	return "life-lessons-api"
+}
+
+// Retreives audience of JWT tokens from environment variable
+func GetJWTAudience() string{
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != ""{
+		return audience
+	}
+	return "life-lessons-app"
+}
+
+// Claims of access token
+// User is identified by subject, never by credentials
+type AccessClaims struct{
+	SessionId string `json:"sid"`
+	Actor *ActorClaim `json:"act,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Party acting on behalf of the subject (RFC 8693), set only on impersonation tokens
+type ActorClaim struct{
+	Subject string `json:"sub"`
+}
+
 // Generate JWT token with given data
-func GenerateJWTToken(admin bool, email, password string)(string, error){
-	secret, err := GetJWTSecret()
+func GenerateJWTToken(userId, sessionId string)(string, error){
+	tokenId, err := GenerateOpaqueToken()
+	if err != nil{
+		return "", err
+	}
+
+	now := time.Now()
+	claims := AccessClaims{
+		SessionId: sessionId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: userId,
+			ID: tokenId,
+			Issuer: GetJWTIssuer(),
+			Audience: jwt.ClaimStrings{GetJWTAudience()},
+			IssuedAt: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ACCESSTOKENEXPIRY)),
+		},
+	}
+	return signToken(claims)
+}
+
+/*
+Access token letting admin @actorId act as @userId
+Bound to the session of the admin, no refresh token is issued for it
+*/
+func GenerateImpersonationToken(userId, actorId, actorSessionId string, expiry time.Duration)(string, error){
+	tokenId, err := GenerateOpaqueToken()
 	if err != nil{
 		return "", err
 	}
 
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
+	now := time.Now()
+	claims := AccessClaims{
+		SessionId: actorSessionId,
+		Actor: &ActorClaim{Subject: actorId},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: userId,
+			ID: tokenId,
+			Issuer: GetJWTIssuer(),
+			Audience: jwt.ClaimStrings{GetJWTAudience()},
+			IssuedAt: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
+		},
+	}
+	return signToken(claims)
+}
 
-	claims["admin"] = admin
-	claims["email"] = email
-	claims["password"] = password
-	claims["exp"] = time.Now().Add(time.Hour*24).Unix()
+// Signs with active asymmetric key when configured, otherwise with JWT_SECRET
+func signToken(claims jwt.Claims)(string, error){
+	if keySet != nil{
+		token := jwt.NewWithClaims(keySet.Signing.Method, claims)
+		token.Header["kid"] = keySet.Signing.Kid
+		return token.SignedString(keySet.Signing.PrivateKey)
+	}
+
+	secret, err := GetJWTSecret()
+	if err != nil{
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(secret)
 }
 
+// Parses access token and verifies its signature, expiry, issuer and audience
+func ParseAccessToken(tokenString string)(*AccessClaims, error){
+	var claims AccessClaims
+	token, err := jwt.ParseWithClaims(tokenString, &claims, verificationKey)
+	if err != nil{
+		return nil, err
+	}
+	if !token.Valid{
+		return nil, errors.New("invalid jwt token")
+	}
+	if !claims.VerifyIssuer(GetJWTIssuer(), true) || !claims.VerifyAudience(GetJWTAudience(), true){
+		return nil, errors.New("token not issued for this service")
+	}
+	// Token id and expiry are needed to revoke the token
+	if claims.Subject == "" || claims.ID == "" || claims.IssuedAt == nil || claims.ExpiresAt == nil{
+		return nil, errors.New("invalid jwt token")
+	}
+	return &claims, nil
+}
+
+
+/*
+Short lived token proving that a step of a multi step flow was completed,
+e.g. password accepted but second factor still required
+Audience is bound to @purpose so that it is never accepted as an access token
+*/
+func GenerateChallengeToken(userId, purpose string, expiry time.Duration)(string, error){
+	tokenId, err := GenerateOpaqueToken()
+	if err != nil{
+		return "", err
+	}
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Subject: userId,
+		ID: tokenId,
+		Issuer: GetJWTIssuer(),
+		Audience: jwt.ClaimStrings{GetJWTAudience() + ":" + purpose},
+		IssuedAt: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
+	}
+	return signToken(claims)
+}
+
+func ParseChallengeToken(tokenString, purpose string)(*jwt.RegisteredClaims, error){
+	var claims jwt.RegisteredClaims
+	token, err := jwt.ParseWithClaims(tokenString, &claims, verificationKey)
+	if err != nil{
+		return nil, err
+	}
+	if !token.Valid || claims.Subject == ""{
+		return nil, errors.New("invalid challenge token")
+	}
+	if !claims.VerifyIssuer(GetJWTIssuer(), true) || !claims.VerifyAudience(GetJWTAudience() + ":" + purpose, true){
+		return nil, errors.New("invalid challenge token")
+	}
+	return &claims, nil
+}
+
 
 type DataType uint16
 
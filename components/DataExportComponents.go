@@ -0,0 +1,298 @@
+package components
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"rest-api/models"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+)
+
+const (
+	// How long the download link of a data export works
+	DATAEXPORTEXPIRY = 24 * time.Hour
+	// Exports pending longer were interrupted, e.g. by a restart, and are marked failed
+	DATAEXPORTTIMEOUT = 30 * time.Minute
+	// How often interrupted exports and expired archives are looked for
+	DATAEXPORTCLEANUPINTERVAL = time.Hour
+)
+
+// Everything tied to a user, as written to the archive
+type UserData struct{
+	ExportedOn time.Time `json:"exportedOn"`
+	Profile models.User `json:"profile"`
+	Lessons []ExportedLesson `json:"lessons"`
+	Comments []models.Comment `json:"comments"`
+	LikedLessons []ExportedLesson `json:"likedLessons"`
+	Sessions []models.Session `json:"sessions"`
+	AuditEvents []models.AuditEvent `json:"auditEvents"`
+}
+
+/*
+Lesson as written to the archive
+Likes and comments are only counted, their ids would tell about other users
+*/
+type ExportedLesson struct{
+	ID string `json:"_id"`
+	UserId string `json:"userId,omitempty"`
+	Username string `json:"username"`
+	Title string `json:"title"`
+	Learning string `json:"learning"`
+	RelatedStory string `json:"relatedStory"`
+	CreatedOn time.Time `json:"createdOn"`
+	CategoryId string `json:"categoryId"`
+	Anonymous bool `json:"anonymous"`
+	LikeCount int `json:"likeCount"`
+	CommentCount int `json:"commentCount"`
+}
+
+func newExportedLessons(plls []models.PersonalLifeLesson) []ExportedLesson{
+	lessons := make([]ExportedLesson, 0, len(plls))
+	for _, pll := range plls{
+		lessons = append(lessons, ExportedLesson{
+			ID: pll.ID,
+			UserId: pll.UserId,
+			Username: pll.Username,
+			Title: pll.Title,
+			Learning: pll.Learning,
+			RelatedStory: pll.RelatedStory,
+			CreatedOn: pll.CreatedOn,
+			CategoryId: pll.CategoryId,
+			Anonymous: pll.Anonymous,
+			LikeCount: len(pll.Likes),
+			CommentCount: len(pll.Comments),
+		})
+	}
+	return lessons
+}
+
+/*
+Assembles data exports of users in the background
+*/
+type DataExporter struct{
+	UserColl *mongo.Collection
+	PllColl *mongo.Collection
+	CommentColl *mongo.Collection
+	SessionColl *mongo.Collection
+	AuditColl *mongo.Collection
+	ExportColl *mongo.Collection
+	Archives *gridfs.Bucket
+	Mailer Mailer
+}
+
+/*
+Cleans up after exports every @interval until the process exits
+First run happens right away, so exports interrupted by a restart don't stay pending
+*/
+func (exporter *DataExporter) Start(interval time.Duration){
+	go func(){
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			exporter.CleanUp(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+func (exporter *DataExporter) CleanUp(now time.Time){
+	if err := models.FailStaleDataExports(now.Add(-DATAEXPORTTIMEOUT), exporter.ExportColl); err != nil{
+		log.Println("Unable to fail interrupted data exports:", err.Error())
+	}
+	if err := models.DeleteExpiredDataExportArchives(now, exporter.Archives); err != nil{
+		log.Println("Unable to delete expired data export archives:", err.Error())
+	}
+}
+
+/*
+Builds the archive of @exportId for the user and mails them @downloadLink once it is ready
+Failures are saved on the export so the user can ask for a new one
+*/
+func (exporter *DataExporter) Export(exportId string, user *models.User, downloadLink string){
+	archive, err := exporter.buildArchive(user.ID)
+	if err == nil{
+		err = models.CompleteDataExport(exportId, user.ID, archive, time.Now().Add(DATAEXPORTEXPIRY), exporter.ExportColl, exporter.Archives)
+	}
+	if err != nil{
+		log.Println("Unable to export data of", user.ID, ":", err.Error())
+		if err := models.FailDataExport(exportId, err.Error(), exporter.ExportColl); err != nil{
+			log.Println("Unable to mark data export", exportId, "as failed:", err.Error())
+		}
+		return
+	}
+
+	err = exporter.Mailer.Send(Mail{
+		To: user.Email,
+		Subject: "Your data export is ready",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"The copy of your data you asked for is ready. Download it from the following link, it is valid for 24 hours:\n" +
+			downloadLink + "\n",
+	})
+	if err != nil{
+		log.Println("Unable to send data export notice to", user.Email, ":", err.Error())
+	}
+}
+
+func (exporter *DataExporter) collect(userId string)(*UserData, error){
+	data := &UserData{ExportedOn: time.Now()}
+
+	profile, err := models.GetUserById(userId, exporter.UserColl)
+	if err != nil{
+		return nil, err
+	}
+	data.Profile = *profile
+	lessons, err := models.GetPllsOfUser(userId, exporter.PllColl)
+	if err != nil{
+		return nil, err
+	}
+	data.Lessons = newExportedLessons(lessons)
+	if data.Comments, err = models.GetCommentsOfUser(userId, exporter.CommentColl); err != nil{
+		return nil, err
+	}
+	likedLessons, err := models.GetPllsLikedByUser(userId, exporter.PllColl)
+	if err != nil{
+		return nil, err
+	}
+	if data.Sessions, err = models.GetAllUserSessions(userId, exporter.SessionColl); err != nil{
+		return nil, err
+	}
+	if data.AuditEvents, err = models.GetAllUserAuditEvents(userId, exporter.AuditColl); err != nil{
+		return nil, err
+	}
+
+	// Liking a lesson doesn't reveal who wrote it anonymously
+	for i := range likedLessons{
+		if likedLessons[i].UserId != userId{
+			likedLessons[i].HideAuthor()
+		}
+	}
+	data.LikedLessons = newExportedLessons(likedLessons)
+	return data, nil
+}
+
+/*
+Zip holds one JSON file per section and data.md rendering all of them for people
+*/
+func (exporter *DataExporter) buildArchive(userId string)([]byte, error){
+	data, err := exporter.collect(userId)
+	if err != nil{
+		return nil, err
+	}
+
+	files := []struct{
+		name string
+		content interface{}
+	}{
+		{"profile.json", data.Profile},
+		{"lessons.json", data.Lessons},
+		{"comments.json", data.Comments},
+		{"likedLessons.json", data.LikedLessons},
+		{"sessions.json", data.Sessions},
+		{"auditEvents.json", data.AuditEvents},
+	}
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for _, file := range files{
+		content, err := json.MarshalIndent(file.content, "", "  ")
+		if err != nil{
+			return nil, err
+		}
+		if err := writeArchiveFile(archive, file.name, content, data.ExportedOn); err != nil{
+			return nil, err
+		}
+	}
+	if err := writeArchiveFile(archive, "data.md", []byte(renderUserDataMarkdown(data)), data.ExportedOn); err != nil{
+		return nil, err
+	}
+	if err := archive.Close(); err != nil{
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func writeArchiveFile(archive *zip.Writer, name string, content []byte, modified time.Time) error{
+	writer, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil{
+		return err
+	}
+	_, err = writer.Write(content)
+	return err
+}
+
+func renderUserDataMarkdown(data *UserData) string{
+	var md strings.Builder
+	date := func(t time.Time) string{
+		if t.IsZero(){
+			return "-"
+		}
+		return t.UTC().Format(time.RFC1123)
+	}
+
+	fmt.Fprintf(&md, "# Data of %s\n\nExported on %s\n\n", data.Profile.Username, date(data.ExportedOn))
+
+	md.WriteString("## Profile\n\n")
+	fmt.Fprintf(&md, "- **Username:** %s\n", data.Profile.Username)
+	fmt.Fprintf(&md, "- **Email:** %s\n", data.Profile.Email)
+	if data.Profile.Handle != ""{
+		fmt.Fprintf(&md, "- **Handle:** %s\n", data.Profile.Handle)
+	}
+	if data.Profile.Bio != ""{
+		fmt.Fprintf(&md, "- **Bio:** %s\n", data.Profile.Bio)
+	}
+	if data.Profile.Photo != ""{
+		fmt.Fprintf(&md, "- **Photo:** %s\n", data.Profile.Photo)
+	}
+	fmt.Fprintf(&md, "- **Role:** %s\n", data.Profile.Role)
+	fmt.Fprintf(&md, "- **Joined on:** %s\n", date(data.Profile.JoinedOn))
+	fmt.Fprintf(&md, "- **Email verified:** %t\n", data.Profile.Verified)
+	fmt.Fprintf(&md, "- **Two factor authentication:** %t\n\n", data.Profile.TOTPEnabled)
+
+	fmt.Fprintf(&md, "## Lessons (%d)\n\n", len(data.Lessons))
+	for _, pll := range data.Lessons{
+		fmt.Fprintf(&md, "### %s\n\n", pll.Title)
+		fmt.Fprintf(&md, "Posted on %s as %s", date(pll.CreatedOn), pll.Username)
+		if pll.Anonymous{
+			md.WriteString(" (anonymous)")
+		}
+		fmt.Fprintf(&md, ", %d likes, %d comments\n\n", pll.LikeCount, pll.CommentCount)
+		fmt.Fprintf(&md, "**Learning:** %s\n\n", pll.Learning)
+		if pll.RelatedStory != ""{
+			fmt.Fprintf(&md, "**Related story:** %s\n\n", pll.RelatedStory)
+		}
+	}
+
+	fmt.Fprintf(&md, "## Comments (%d)\n\n", len(data.Comments))
+	for _, comment := range data.Comments{
+		fmt.Fprintf(&md, "- %s on lesson %s: %s\n", date(comment.CommentedOn), comment.PllId, comment.Comment)
+	}
+	md.WriteString("\n")
+
+	fmt.Fprintf(&md, "## Liked lessons (%d)\n\n", len(data.LikedLessons))
+	for _, pll := range data.LikedLessons{
+		fmt.Fprintf(&md, "- %s by %s\n", pll.Title, pll.Username)
+	}
+	md.WriteString("\n")
+
+	fmt.Fprintf(&md, "## Sessions (%d)\n\n", len(data.Sessions))
+	for _, session := range data.Sessions{
+		status := "active"
+		if session.Revoked{
+			status = "signed out"
+		}
+		fmt.Fprintf(&md, "- Signed in on %s from %s (%s), last seen %s, %s\n", date(session.CreatedOn), session.IP, session.UserAgent, date(session.LastSeenOn), status)
+	}
+	md.WriteString("\n")
+
+	fmt.Fprintf(&md, "## Security activity (%d)\n\n", len(data.AuditEvents))
+	for _, event := range data.AuditEvents{
+		fmt.Fprintf(&md, "- %s: %s (%s) from %s\n", date(event.CreatedOn), event.Type, event.Outcome, event.IP)
+	}
+	return md.String()
+}
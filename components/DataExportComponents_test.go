@@ -0,0 +1,80 @@
+package components
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func lessonDocument(id, userId string, anonymous bool) bson.D{
+	return bson.D{
+		{Key: "_id", Value: id},
+		{Key: "userId", Value: userId},
+		{Key: "username", Value: "author"},
+		{Key: "likes", Value: bson.A{"liker", "other"}},
+		{Key: "comments", Value: bson.A{"comment"}},
+		{Key: "anonymous", Value: anonymous},
+	}
+}
+
+func TestCollectLessons(t *testing.T){
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("likes and comments are only counted", func(mt *mtest.T){
+		id := primitive.NewObjectID()
+		userId := id.Hex()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch, bson.D{{Key: "_id", Value: id}, {Key: "username", Value: "liker"}}),
+			mtest.CreateCursorResponse(0, "db.Pll", mtest.FirstBatch, lessonDocument("own", userId, false)),
+			mtest.CreateCursorResponse(0, "db.Comments", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "db.Pll", mtest.FirstBatch,
+				lessonDocument("own", userId, false),
+				lessonDocument("public", "author", false),
+				lessonDocument("anonymous", "author", true),
+			),
+			mtest.CreateCursorResponse(0, "db.Sessions", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "db.AuditEvents", mtest.FirstBatch),
+		)
+		exporter := &DataExporter{
+			UserColl: mt.DB.Collection("Users"),
+			PllColl: mt.DB.Collection("Pll"),
+			CommentColl: mt.DB.Collection("Comments"),
+			SessionColl: mt.DB.Collection("Sessions"),
+			AuditColl: mt.DB.Collection("AuditEvents"),
+		}
+		data, err := exporter.collect(userId)
+		if err != nil{
+			mt.Fatal(err)
+		}
+		if len(data.Lessons) != 1 || data.Lessons[0].LikeCount != 2 || data.Lessons[0].CommentCount != 1{
+			mt.Fatalf("expected own lesson with counts, got %+v", data.Lessons)
+		}
+		if len(data.LikedLessons) != 3{
+			mt.Fatalf("expected 3 liked lessons, got %d", len(data.LikedLessons))
+		}
+
+		own, public, anonymous := data.LikedLessons[0], data.LikedLessons[1], data.LikedLessons[2]
+		if own.LikeCount != 2 || own.CommentCount != 1 || public.LikeCount != 2 || public.CommentCount != 1{
+			mt.Fatalf("expected likes and comments counted, got %+v %+v", own, public)
+		}
+		if public.UserId != "author" || anonymous.UserId != ""{
+			mt.Fatalf("expected only the anonymous author hidden, got %q %q", public.UserId, anonymous.UserId)
+		}
+
+		// Ids of other users who liked or commented never reach the archive
+		for _, lessons := range [][]ExportedLesson{data.Lessons, data.LikedLessons}{
+			encoded, err := json.Marshal(lessons)
+			if err != nil{
+				mt.Fatal(err)
+			}
+			if strings.Contains(string(encoded), "other") || strings.Contains(string(encoded), "comment\""){
+				mt.Fatalf("expected only counts, got %s", encoded)
+			}
+		}
+	})
+}
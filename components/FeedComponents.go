@@ -0,0 +1,250 @@
+package components
+
+import (
+	"errors"
+	"os"
+	"rest-api/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Hidden users change rarely, feeds reuse the set for this long
+const HIDDENUSERCACHETTL = time.Minute
+
+// Where lessons of a feed page come from
+const (
+	FEEDSOURCEFOLLOWING = "following"
+	FEEDSOURCEPOPULAR = "popular"
+)
+
+type FeedPage struct{
+	Lessons []models.PersonalLifeLesson `json:"lessons"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	Source string `json:"source"`
+}
+
+/*
+Assembles the lessons shown to a user
+@cursor is empty for the first page, afterwards the NextCursor of previous page
+*/
+type Feed interface{
+	GetFeed(userId, cursor string, limit int64) (*FeedPage, error)
+}
+
+/*
+Orders lessons matching a filter and pages through them
+Cursors are opaque to everyone but the ranker issuing them, empty cursor means no more lessons
+*/
+type FeedRanker interface{
+	Rank(filter bson.M, cursor string, limit int64) ([]models.PersonalLifeLesson, string, error)
+}
+
+var ErrInvalidFeedCursor = errors.New("invalid feed cursor")
+
+// Latest lessons first
+type LatestRanker struct{
+	coll *mongo.Collection
+}
+
+func NewLatestRanker(coll *mongo.Collection) *LatestRanker{
+	return &LatestRanker{coll: coll}
+}
+
+func (ranker *LatestRanker) Rank(filter bson.M, cursor string, limit int64)([]models.PersonalLifeLesson, string, error){
+	var before primitive.ObjectID
+	if cursor != ""{
+		id, err := primitive.ObjectIDFromHex(cursor)
+		if err != nil{
+			return nil, "", ErrInvalidFeedCursor
+		}
+		before = id
+	}
+	plls, err := models.GetLatestPlls(filter, before, limit, ranker.coll)
+	if err != nil || int64(len(plls)) < limit{
+		return plls, "", err
+	}
+	return plls, plls[len(plls)-1].ID, nil
+}
+
+// Most liked lessons first
+type PopularRanker struct{
+	coll *mongo.Collection
+}
+
+func NewPopularRanker(coll *mongo.Collection) *PopularRanker{
+	return &PopularRanker{coll: coll}
+}
+
+// Cursor is "<likeCount>.<lessonId>" of the last lesson
+func (ranker *PopularRanker) Rank(filter bson.M, cursor string, limit int64)([]models.PersonalLifeLesson, string, error){
+	var after *models.PopularityCursor
+	if cursor != ""{
+		likeCount, lastId, found := strings.Cut(cursor, ".")
+		if !found{
+			return nil, "", ErrInvalidFeedCursor
+		}
+		count, err := strconv.ParseInt(likeCount, 10, 64)
+		if err != nil{
+			return nil, "", ErrInvalidFeedCursor
+		}
+		id, err := primitive.ObjectIDFromHex(lastId)
+		if err != nil{
+			return nil, "", ErrInvalidFeedCursor
+		}
+		after = &models.PopularityCursor{LikeCount: count, LastId: id}
+	}
+	plls, err := models.GetPopularPlls(filter, after, limit, ranker.coll)
+	if err != nil || int64(len(plls)) < limit{
+		return plls, "", err
+	}
+	last := plls[len(plls)-1]
+	return plls, strconv.Itoa(len(last.Likes)) + "." + last.ID, nil
+}
+
+/*
+Selects ranker of the home feed through FEED_RANKING (latest or popular)
+*/
+func NewFeedRankerFromEnv(pllColl *mongo.Collection)(FeedRanker, error){
+	switch os.Getenv("FEED_RANKING"){
+	case "", "latest":
+		return NewLatestRanker(pllColl), nil
+	case "popular":
+		return NewPopularRanker(pllColl), nil
+	default:
+		return nil, errors.New("unknown FEED_RANKING " + os.Getenv("FEED_RANKING"))
+	}
+}
+
+/*
+Ids of hidden users shared by every feed request, so feeds don't scan users each time
+Bans and deletions take up to the ttl to show in feeds
+*/
+type HiddenUserCache struct{
+	coll *mongo.Collection
+	ttl time.Duration
+
+	mutex sync.Mutex
+	userIds []string
+	fetchedOn time.Time
+}
+
+func NewHiddenUserCache(coll *mongo.Collection, ttl time.Duration) *HiddenUserCache{
+	return &HiddenUserCache{coll: coll, ttl: ttl}
+}
+
+// Returns a copy the caller may append to
+func (cache *HiddenUserCache) Get()([]string, error){
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.userIds == nil || time.Since(cache.fetchedOn) >= cache.ttl{
+		userIds, err := models.GetHiddenUserIds(cache.coll)
+		if err != nil{
+			return nil, err
+		}
+		cache.userIds, cache.fetchedOn = userIds, time.Now()
+	}
+	return append([]string{}, cache.userIds...), nil
+}
+
+/*
+Merges lessons of followed authors and subscribed categories
+Lessons of hidden, blocked and muted authors and of users who blocked the reader are left out
+Users following nobody and subscribed to nothing get popular lessons instead
+*/
+type HomeFeed struct{
+	ranker FeedRanker
+	fallback FeedRanker
+	hiddenUsers *HiddenUserCache
+	followColl *mongo.Collection
+	subscriptionColl *mongo.Collection
+	relationColl *mongo.Collection
+}
+
+func NewHomeFeed(ranker FeedRanker, pllColl, userColl, followColl, subscriptionColl, relationColl *mongo.Collection) *HomeFeed{
+	return &HomeFeed{
+		ranker: ranker,
+		fallback: NewPopularRanker(pllColl),
+		hiddenUsers: NewHiddenUserCache(userColl, HIDDENUSERCACHETTL),
+		followColl: followColl,
+		subscriptionColl: subscriptionColl,
+		relationColl: relationColl,
+	}
+}
+
+/*
+Cursor of the home feed is "<source>:<ranker cursor>"
+so paging keeps using the source of the first page
+*/
+func (feed *HomeFeed) GetFeed(userId, cursor string, limit int64)(*FeedPage, error){
+	source, rankerCursor := "", ""
+	if cursor != ""{
+		var found bool
+		source, rankerCursor, found = strings.Cut(cursor, ":")
+		if !found || (source != FEEDSOURCEFOLLOWING && source != FEEDSOURCEPOPULAR){
+			return nil, ErrInvalidFeedCursor
+		}
+	}
+
+	// Authors the user shouldn't see
+	excludedUserIds, err := feed.hiddenUsers.Get()
+	if err != nil{
+		return nil, err
+	}
+	relatedUserIds, err := models.GetExcludedUserIds(userId, feed.relationColl)
+	if err != nil{
+		return nil, err
+	}
+	excludedUserIds = append(excludedUserIds, relatedUserIds...)
+
+	// Audience of the user
+	followeeIds, err := models.GetFolloweeIds(userId, feed.followColl)
+	if err != nil{
+		return nil, err
+	}
+	subscriptions, err := models.GetCategorySubscriptions(userId, feed.subscriptionColl)
+	if err != nil{
+		return nil, err
+	}
+	if source == ""{
+		source = FEEDSOURCEFOLLOWING
+		if len(followeeIds) == 0 && len(subscriptions) == 0{
+			source = FEEDSOURCEPOPULAR
+		}
+	}
+
+	var plls []models.PersonalLifeLesson
+	var nextCursor string
+	if source == FEEDSOURCEPOPULAR{
+		filter := bson.M{"userId": bson.M{"$nin": append(excludedUserIds, userId)}}
+		plls, nextCursor, err = feed.fallback.Rank(filter, rankerCursor, limit)
+	}else{
+		// Anonymous lessons of followed authors are left out, they'd reveal the author
+		categoryIds := make([]string, 0, len(subscriptions))
+		for _, subscription := range subscriptions{
+			categoryIds = append(categoryIds, subscription.CategoryId)
+		}
+		filter := bson.M{
+			"userId": bson.M{"$nin": excludedUserIds},
+			"$or": bson.A{
+				bson.M{"userId": bson.M{"$in": followeeIds}, "anonymous": bson.M{"$ne": true}},
+				bson.M{"categoryId": bson.M{"$in": categoryIds}},
+			},
+		}
+		plls, nextCursor, err = feed.ranker.Rank(filter, rankerCursor, limit)
+	}
+	if err != nil{
+		return nil, err
+	}
+
+	page := &FeedPage{Lessons: plls, Source: source}
+	if nextCursor != ""{
+		page.NextCursor = source + ":" + nextCursor
+	}
+	return page, nil
+}
@@ -0,0 +1,49 @@
+package components
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestHiddenUserCache(t *testing.T){
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("users are read once per ttl", func(mt *mtest.T){
+		hidden := bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "banned", Value: true}, {Key: "contentHidden", Value: true}}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch, hidden),
+			mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch),
+		)
+		cache := NewHiddenUserCache(mt.Coll, time.Minute)
+
+		for i := 0; i < 3; i++{
+			userIds, err := cache.Get()
+			if err != nil{
+				mt.Fatal(err)
+			}
+			// Callers changing their copy don't change the cached set
+			if len(userIds) != 1 || userIds[0] != hidden[0].Value.(primitive.ObjectID).Hex(){
+				mt.Fatalf("expected the hidden user, got %v", userIds)
+			}
+			userIds[0] = "reader"
+		}
+		if count := len(mt.GetAllStartedEvents()); count != 1{
+			mt.Fatalf("expected a single read within the ttl, got %d", count)
+		}
+
+		// Unban shows up once the ttl passed
+		cache.fetchedOn = time.Now().Add(-time.Minute)
+		userIds, err := cache.Get()
+		if err != nil{
+			mt.Fatal(err)
+		}
+		if len(userIds) != 0 || len(mt.GetAllStartedEvents()) != 2{
+			mt.Fatalf("expected reread after the ttl, got %v", userIds)
+		}
+	})
+}
@@ -0,0 +1,244 @@
+package components
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Key used for signing or verifying JWT tokens
+// PrivateKey is nil for keys which are only kept for verification
+type SigningKey struct{
+	Kid string
+	Method jwt.SigningMethod
+	PrivateKey crypto.PrivateKey
+	PublicKey crypto.PublicKey
+}
+
+// Active signing key along with every key accepted for verification
+// HS256 tokens signed with JWT_SECRET are only accepted before AcceptSecretUntil
+type KeySet struct{
+	Signing *SigningKey
+	Verification map[string]*SigningKey
+	AcceptSecretUntil time.Time
+}
+
+// JSON Web Key as published in the JWKS document
+type JWK struct{
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X string `json:"x,omitempty"`
+}
+
+type JWKS struct{
+	Keys []JWK `json:"keys"`
+}
+
+// Loaded once on startup, nil when tokens are signed with JWT_SECRET
+var keySet *KeySet
+
+/*
+Loads asymmetric keys for signing JWT tokens
+	JWT_KEYS_DIR: directory with PEM files named "<kid>.pem", every key in it is accepted for verification
+	JWT_SIGNING_KID: kid of the private key used for signing new tokens
+	JWT_SECRET_ACCEPTED_UNTIL: RFC 3339 time until which tokens signed with JWT_SECRET are still accepted,
+		for moving from JWT_SECRET to keys without signing everyone out, unset rejects them right away
+Rotating keys is done by adding a new key, switching JWT_SIGNING_KID to it
+and removing the old key once every token signed by it has expired
+Without JWT_KEYS_DIR tokens are signed with JWT_SECRET (HS256)
+*/
+func LoadKeySet() error{
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == ""{
+		keySet = nil
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil{
+		return err
+	}
+	set := KeySet{Verification: make(map[string]*SigningKey)}
+	for _, path := range paths{
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := loadSigningKey(kid, path)
+		if err != nil{
+			return errors.New(path + ": " + err.Error())
+		}
+		set.Verification[kid] = key
+	}
+
+	// Selecting key for signing new tokens
+	signingKid := os.Getenv("JWT_SIGNING_KID")
+	if signingKid == ""{
+		return errors.New("JWT_SIGNING_KID not set")
+	}
+	signing, ok := set.Verification[signingKid]
+	if !ok || signing.PrivateKey == nil{
+		return errors.New("no private key found for JWT_SIGNING_KID " + signingKid)
+	}
+	set.Signing = signing
+
+	if value := os.Getenv("JWT_SECRET_ACCEPTED_UNTIL"); value != ""{
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil{
+			return errors.New("invalid JWT_SECRET_ACCEPTED_UNTIL")
+		}
+		set.AcceptSecretUntil = until
+	}
+
+	keySet = &set
+	return nil
+}
+
+// Parses RSA or Ed25519 key from PEM file
+func loadSigningKey(kid, path string)(*SigningKey, error){
+	data, err := os.ReadFile(path)
+	if err != nil{
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil{
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	switch block.Type{
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, errors.New("unsupported PEM block " + block.Type)
+	}
+	if err != nil{
+		return nil, err
+	}
+
+	key := SigningKey{Kid: kid}
+	switch k := parsed.(type){
+	case *rsa.PrivateKey:
+		key.Method, key.PrivateKey, key.PublicKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.PublicKey = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.PrivateKey, key.PublicKey = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.PublicKey = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+	return &key, nil
+}
+
+// Returns key to verify the token with, based on its "kid" header
+func verificationKey(t *jwt.Token)(interface{}, error){
+	kid, hasKid := t.Header["kid"].(string)
+	if !hasKid{
+		// Tokens without kid are signed by JWT_SECRET, which is retired once keys are configured
+		if t.Method != jwt.SigningMethodHS256{
+			return "", errors.New("unable to parse token")
+		}
+		if keySet != nil && !time.Now().Before(keySet.AcceptSecretUntil){
+			return "", errors.New("tokens signed with JWT_SECRET are no longer accepted")
+		}
+		return GetJWTSecret()
+	}
+	if keySet == nil{
+		return "", errors.New("unknown signing key")
+	}
+	key, ok := keySet.Verification[kid]
+	if !ok{
+		return "", errors.New("unknown signing key")
+	}
+	if t.Method.Alg() != key.Method.Alg(){
+		return "", errors.New("unexpected signing method")
+	}
+	return key.PublicKey, nil
+}
+
+/*
+Returns public part of every verification key
+For services verifying our tokens without sharing a secret
+*/
+func GetJWKS() JWKS{
+	jwks := JWKS{Keys: make([]JWK, 0)}
+	if keySet == nil{
+		return jwks
+	}
+	for kid, key := range keySet.Verification{
+		jwk := JWK{Kid: kid, Use: "sig", Alg: key.Method.Alg()}
+		switch k := key.PublicKey.(type){
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+	return jwks
+}
+
+/*
+Converts JWK published by someone else back to a public key
+Along with the signing method tokens signed by it must use
+*/
+func (jwk *JWK) PublicKey()(crypto.PublicKey, jwt.SigningMethod, error){
+	switch jwk.Kty{
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil{
+			return nil, nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil{
+			return nil, nil, err
+		}
+		key := rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		method := jwt.GetSigningMethod(jwk.Alg)
+		if method == nil{
+			method = jwt.SigningMethodRS256
+		}
+		if _, ok := method.(*jwt.SigningMethodRSA); !ok{
+			return nil, nil, errors.New("unsupported algorithm " + jwk.Alg + " for RSA key")
+		}
+		return &key, method, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519"{
+			return nil, nil, errors.New("unsupported curve " + jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize{
+			return nil, nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, nil, errors.New("unsupported key type " + jwk.Kty)
+	}
+}
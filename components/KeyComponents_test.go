@@ -0,0 +1,126 @@
+package components
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func testClaims() AccessClaims{
+	now := time.Now()
+	return AccessClaims{
+		SessionId: "session",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: "user",
+			ID: "token",
+			Issuer: GetJWTIssuer(),
+			Audience: jwt.ClaimStrings{GetJWTAudience()},
+			IssuedAt: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+}
+
+func signWith(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string{
+	t.Helper()
+	token := jwt.NewWithClaims(method, testClaims())
+	if kid != ""{
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil{
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// Installs a key set for the test and restores the previous one afterwards
+func useKeySet(t *testing.T, set *KeySet){
+	t.Helper()
+	previous := keySet
+	keySet = set
+	t.Cleanup(func(){ keySet = previous })
+}
+
+func TestVerificationKey(t *testing.T){
+	t.Setenv("JWT_SECRET", "secret")
+
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil{
+		t.Fatal(err)
+	}
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil{
+		t.Fatal(err)
+	}
+	_, otherEdPrivate, _ := ed25519.GenerateKey(rand.Reader)
+
+	edKey := &SigningKey{Kid: "ed", Method: jwt.SigningMethodEdDSA, PrivateKey: edPrivate, PublicKey: edPrivate.Public()}
+	rsaKey := &SigningKey{Kid: "rsa", Method: jwt.SigningMethodRS256, PrivateKey: rsaPrivate, PublicKey: &rsaPrivate.PublicKey}
+	newKeySet := func(acceptSecretUntil time.Time) *KeySet{
+		return &KeySet{
+			Signing: edKey,
+			Verification: map[string]*SigningKey{"ed": edKey, "rsa": rsaKey},
+			AcceptSecretUntil: acceptSecretUntil,
+		}
+	}
+
+	tests := []struct{
+		name string
+		set *KeySet
+		token string
+		valid bool
+	}{
+		{"secret without keys", nil, signWith(t, jwt.SigningMethodHS256, "", []byte("secret")), true},
+		{"wrong secret", nil, signWith(t, jwt.SigningMethodHS256, "", []byte("other")), false},
+		{"HS512 secret", nil, signWith(t, jwt.SigningMethodHS512, "", []byte("secret")), false},
+		{"kid without keys", nil, signWith(t, jwt.SigningMethodEdDSA, "ed", edPrivate), false},
+		{"secret after moving to keys", newKeySet(time.Time{}), signWith(t, jwt.SigningMethodHS256, "", []byte("secret")), false},
+		{"secret during transition", newKeySet(time.Now().Add(time.Hour)), signWith(t, jwt.SigningMethodHS256, "", []byte("secret")), true},
+		{"secret after transition", newKeySet(time.Now().Add(-time.Hour)), signWith(t, jwt.SigningMethodHS256, "", []byte("secret")), false},
+		{"EdDSA key", newKeySet(time.Time{}), signWith(t, jwt.SigningMethodEdDSA, "ed", edPrivate), true},
+		{"RS256 key", newKeySet(time.Time{}), signWith(t, jwt.SigningMethodRS256, "rsa", rsaPrivate), true},
+		{"unknown kid", newKeySet(time.Time{}), signWith(t, jwt.SigningMethodEdDSA, "missing", edPrivate), false},
+		{"other key under known kid", newKeySet(time.Time{}), signWith(t, jwt.SigningMethodEdDSA, "ed", otherEdPrivate), false},
+		{"algorithm not matching kid", newKeySet(time.Time{}), signWith(t, jwt.SigningMethodEdDSA, "rsa", edPrivate), false},
+		{"HMAC under asymmetric kid", newKeySet(time.Time{}), signWith(t, jwt.SigningMethodHS256, "ed", []byte("secret")), false},
+		{"none algorithm", nil, signWith(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType), false},
+	}
+	for _, test := range tests{
+		t.Run(test.name, func(t *testing.T){
+			useKeySet(t, test.set)
+			_, err := ParseAccessToken(test.token)
+			if test.valid && err != nil{
+				t.Fatalf("expected token to be accepted, got %v", err)
+			}
+			if !test.valid && err == nil{
+				t.Fatal("expected token to be rejected")
+			}
+		})
+	}
+}
+
+func TestSignedTokensVerify(t *testing.T){
+	t.Setenv("JWT_SECRET", "secret")
+	_, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	edKey := &SigningKey{Kid: "ed", Method: jwt.SigningMethodEdDSA, PrivateKey: edPrivate, PublicKey: edPrivate.Public()}
+
+	for _, set := range []*KeySet{nil, {Signing: edKey, Verification: map[string]*SigningKey{"ed": edKey}}}{
+		useKeySet(t, set)
+		token, err := GenerateJWTToken("user", "session")
+		if err != nil{
+			t.Fatal(err)
+		}
+		claims, err := ParseAccessToken(token)
+		if err != nil{
+			t.Fatal(err)
+		}
+		if claims.Subject != "user" || claims.SessionId != "session"{
+			t.Fatalf("unexpected claims %+v", claims)
+		}
+	}
+}
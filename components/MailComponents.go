@@ -0,0 +1,135 @@
+package components
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Mail struct{
+	To string
+	Subject string
+	Body string
+}
+
+// Every email sent by the server goes through a Mailer
+type Mailer interface{
+	Send(mail Mail) error
+}
+
+// Sends emails through SMTP server
+type SMTPMailer struct{
+	Host string
+	Port string
+	Username string
+	Password string
+	From string
+}
+
+func (mailer *SMTPMailer) Send(mail Mail) error{
+	var auth smtp.Auth
+	if mailer.Username != ""{
+		auth = smtp.PlainAuth("", mailer.Username, mailer.Password, mailer.Host)
+	}
+	return smtp.SendMail(mailer.Host+":"+mailer.Port, auth, mailer.From, []string{mail.To}, formatMail(mailer.From, mail))
+}
+
+// Writes emails as .eml files into a directory instead of sending them
+// For development and testing without a mail server
+type OutboxMailer struct{
+	Dir string
+	From string
+}
+
+func (mailer *OutboxMailer) Send(mail Mail) error{
+	if err := os.MkdirAll(mailer.Dir, 0700); err != nil{
+		return err
+	}
+	suffix, err := GenerateOpaqueToken()
+	if err != nil{
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), suffix[:8])
+	return os.WriteFile(filepath.Join(mailer.Dir, name), formatMail(mailer.From, mail), 0600)
+}
+
+func formatMail(from string, mail Mail) []byte{
+	headers := []string{
+		"From: " + from,
+		"To: " + mail.To,
+		"Subject: " + mail.Subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + mail.Body)
+}
+
+/*
+Selects mailer from environment variables
+	MAILER: "smtp" or "outbox" (default)
+	MAIL_FROM: sender address
+	SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD: for smtp mailer
+	MAIL_OUTBOX_DIR: directory of outbox mailer, "outbox" by default
+*/
+func NewMailerFromEnv()(Mailer, error){
+	from := os.Getenv("MAIL_FROM")
+	if from == ""{
+		from = "no-reply@localhost"
+	}
+	switch os.Getenv("MAILER"){
+	case "smtp":
+		mailer := SMTPMailer{
+			Host: os.Getenv("SMTP_HOST"),
+			Port: os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From: from,
+		}
+		if mailer.Host == ""{
+			return nil, errors.New("SMTP_HOST not set")
+		}
+		if mailer.Port == ""{
+			mailer.Port = "587"
+		}
+		return &mailer, nil
+	case "", "outbox":
+		dir := os.Getenv("MAIL_OUTBOX_DIR")
+		if dir == ""{
+			dir = "outbox"
+		}
+		return &OutboxMailer{Dir: dir, From: from}, nil
+	default:
+		return nil, errors.New("unknown MAILER " + os.Getenv("MAILER"))
+	}
+}
+
+// Base url of links sent in emails
+func GetPublicURL() string{
+	if url := os.Getenv("PUBLIC_URL"); url != ""{
+		return strings.TrimSuffix(url, "/")
+	}
+	return "http://localhost:8080"
+}
+
+// Base url of app pages opened from links in emails
+func GetAppURL() string{
+	if url := os.Getenv("APP_URL"); url != ""{
+		return strings.TrimSuffix(url, "/")
+	}
+	return GetPublicURL()
+}
+
+// Checks that email is a plain address like "name@example.com"
+func ValidateEmail(email string) error{
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != ""{
+		return errors.New("invalid email address")
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package components
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Tokens with unknown kid can't make us fetch JWKS of a provider more often than this
+const OIDCJWKSREFETCHINTERVAL = time.Minute
+
+// OpenID Connect provider users can sign in with
+// Anything supporting discovery and authorization code flow works, including a local stub
+type OIDCProvider struct{
+	Name string
+	Issuer string
+	ClientId string
+	ClientSecret string
+	RedirectURL string
+	Scopes []string
+	HTTPClient *http.Client
+
+	mutex sync.Mutex
+	discovery *oidcDiscovery
+	keys map[string]JWK
+	keysFetchedOn time.Time
+}
+
+// Parts of the discovery document that are used
+type oidcDiscovery struct{
+	Issuer string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint string `json:"token_endpoint"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// Identity of the user as asserted by the provider
+type OIDCIdentity struct{
+	Subject string
+	Email string
+	EmailVerified bool
+	Name string
+	Picture string
+}
+
+type oidcClaims struct{
+	Email string `json:"email"`
+	EmailVerified interface{} `json:"email_verified"`
+	Name string `json:"name"`
+	Picture string `json:"picture"`
+	Nonce string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+/*
+Loads providers from environment variables
+	OIDC_PROVIDERS: comma separated provider names, e.g. "google,gitlab"
+	OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET: for each provider
+	OIDC_<NAME>_REDIRECT_URL: optional, callback of this server by default
+	OIDC_<NAME>_SCOPES: optional, "openid email profile" by default
+*/
+func LoadOIDCProviders()(map[string]*OIDCProvider, error){
+	providers := make(map[string]*OIDCProvider)
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ","){
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == ""{
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := OIDCProvider{
+			Name: name,
+			Issuer: strings.TrimSuffix(os.Getenv(prefix + "ISSUER"), "/"),
+			ClientId: os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL: os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes: strings.Fields(os.Getenv(prefix + "SCOPES")),
+			HTTPClient: &http.Client{Timeout: time.Second*10},
+		}
+		if provider.Issuer == "" || provider.ClientId == ""{
+			return nil, errors.New(prefix + "ISSUER and " + prefix + "CLIENT_ID are required")
+		}
+		if provider.RedirectURL == ""{
+			provider.RedirectURL = GetPublicURL() + "/v1/auth/oidc/" + name + "/callback"
+		}
+		if len(provider.Scopes) == 0{
+			provider.Scopes = []string{"openid", "email", "profile"}
+		}
+		providers[name] = &provider
+	}
+	return providers, nil
+}
+
+// Generates PKCE verifier kept by the server and S256 challenge sent to the provider
+func GeneratePKCE()(string, string, error){
+	verifier, err := GenerateOpaqueToken()
+	if err != nil{
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func (provider *OIDCProvider) getJSON(url string, target interface{}) error{
+	response, err := provider.HTTPClient.Get(url)
+	if err != nil{
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK{
+		return fmt.Errorf("%s responded with %d", url, response.StatusCode)
+	}
+	return json.NewDecoder(response.Body).Decode(target)
+}
+
+// Fetches discovery document once and caches it
+func (provider *OIDCProvider) getDiscovery()(*oidcDiscovery, error){
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if provider.discovery != nil{
+		return provider.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := provider.getJSON(provider.Issuer + "/.well-known/openid-configuration", &discovery); err != nil{
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != provider.Issuer{
+		return nil, errors.New("issuer of discovery document doesn't match " + provider.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == ""{
+		return nil, errors.New("incomplete discovery document of " + provider.Issuer)
+	}
+	provider.discovery = &discovery
+	return provider.discovery, nil
+}
+
+/*
+Returns signing key of the provider, refetching JWKS for unknown kid (provider rotated keys)
+Refetching waits for OIDCJWKSREFETCHINTERVAL since the last fetch
+*/
+func (provider *OIDCProvider) getKey(kid string)(*JWK, error){
+	discovery, err := provider.getDiscovery()
+	if err != nil{
+		return nil, err
+	}
+
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if key, ok := provider.keys[kid]; ok{
+		return &key, nil
+	}
+	if time.Since(provider.keysFetchedOn) < OIDCJWKSREFETCHINTERVAL{
+		return nil, errors.New("unknown signing key of provider")
+	}
+
+	// Failed fetches count too, an unreachable provider isn't hammered either
+	provider.keysFetchedOn = time.Now()
+	var jwks JWKS
+	if err := provider.getJSON(discovery.JWKSURI, &jwks); err != nil{
+		return nil, err
+	}
+	provider.keys = make(map[string]JWK)
+	for _, key := range jwks.Keys{
+		provider.keys[key.Kid] = key
+	}
+	if key, ok := provider.keys[kid]; ok{
+		return &key, nil
+	}
+	return nil, errors.New("unknown signing key of provider")
+}
+
+/*
+Url the user is redirected to for signing in at the provider
+*/
+func (provider *OIDCProvider) AuthorizationURL(state, nonce, codeChallenge string)(string, error){
+	discovery, err := provider.getDiscovery()
+	if err != nil{
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.ClientId)
+	query.Set("redirect_uri", provider.RedirectURL)
+	query.Set("scope", strings.Join(provider.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?"){
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+/*
+Exchanges authorization code for id token and verifies it
+*/
+func (provider *OIDCProvider) Exchange(code, codeVerifier, nonce string)(*OIDCIdentity, error){
+	discovery, err := provider.getDiscovery()
+	if err != nil{
+		return nil, err
+	}
+
+	// Requesting tokens from the provider
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.RedirectURL)
+	form.Set("client_id", provider.ClientId)
+	form.Set("code_verifier", codeVerifier)
+	if provider.ClientSecret != ""{
+		form.Set("client_secret", provider.ClientSecret)
+	}
+	request, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil{
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	response, err := provider.HTTPClient.Do(request)
+	if err != nil{
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var tokenResponse struct{
+		IdToken string `json:"id_token"`
+		Error string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&tokenResponse); err != nil{
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK || tokenResponse.Error != ""{
+		return nil, errors.New("provider rejected code: " + tokenResponse.Error + " " + tokenResponse.ErrorDescription)
+	}
+	if tokenResponse.IdToken == ""{
+		return nil, errors.New("provider didn't return an id token")
+	}
+
+	return provider.verifyIdToken(tokenResponse.IdToken, nonce)
+}
+
+func (provider *OIDCProvider) verifyIdToken(idToken, nonce string)(*OIDCIdentity, error){
+	var claims oidcClaims
+	token, err := jwt.ParseWithClaims(idToken, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		jwk, err := provider.getKey(kid)
+		if err != nil{
+			return nil, err
+		}
+		key, method, err := jwk.PublicKey()
+		if err != nil{
+			return nil, err
+		}
+		if t.Method.Alg() != method.Alg(){
+			return nil, errors.New("unexpected signing method")
+		}
+		return key, nil
+	})
+	if err != nil{
+		return nil, err
+	}
+	if !token.Valid || claims.Subject == ""{
+		return nil, errors.New("invalid id token")
+	}
+	if strings.TrimSuffix(claims.Issuer, "/") != provider.Issuer || !claims.VerifyAudience(provider.ClientId, true){
+		return nil, errors.New("id token not issued for this client")
+	}
+	if claims.Nonce != nonce{
+		return nil, errors.New("id token nonce mismatch")
+	}
+
+	// Some providers send email_verified as string
+	emailVerified := false
+	switch verified := claims.EmailVerified.(type){
+	case bool:
+		emailVerified = verified
+	case string:
+		emailVerified = verified == "true"
+	}
+
+	return &OIDCIdentity{
+		Subject: claims.Subject,
+		Email: claims.Email,
+		EmailVerified: emailVerified,
+		Name: claims.Name,
+		Picture: claims.Picture,
+	}, nil
+}
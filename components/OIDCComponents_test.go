@@ -0,0 +1,241 @@
+package components
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+/*
+Local OpenID Connect provider issuing id tokens for a single authorization code
+It checks the PKCE verifier against the challenge of the authorization url like real providers do
+*/
+type stubProvider struct{
+	server *httptest.Server
+	key ed25519.PrivateKey
+	kid string
+
+	mutex sync.Mutex
+	challenge string
+	nonce string
+	audience string
+	jwksFetches int
+}
+
+func newStubProvider(t *testing.T) *stubProvider{
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil{
+		t.Fatal(err)
+	}
+	stub := &stubProvider{key: key, kid: "stub", audience: "client"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request){
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer: stub.server.URL,
+			AuthorizationEndpoint: stub.server.URL + "/authorize",
+			TokenEndpoint: stub.server.URL + "/token",
+			JWKSURI: stub.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request){
+		stub.mutex.Lock()
+		stub.jwksFetches++
+		stub.mutex.Unlock()
+		json.NewEncoder(w).Encode(JWKS{Keys: []JWK{{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			Kid: "stub",
+			X: base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request){
+		stub.mutex.Lock()
+		defer stub.mutex.Unlock()
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("code") != "code" || base64.RawURLEncoding.EncodeToString(sum[:]) != stub.challenge{
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": stub.idToken(t)})
+	})
+	stub.server = httptest.NewServer(mux)
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (stub *stubProvider) idToken(t *testing.T) string{
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, oidcClaims{
+		Email: "user@example.com",
+		EmailVerified: "true",
+		Nonce: stub.nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: "subject",
+			Issuer: stub.server.URL,
+			Audience: jwt.ClaimStrings{stub.audience},
+			IssuedAt: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	})
+	token.Header["kid"] = stub.kid
+	signed, err := token.SignedString(stub.key)
+	if err != nil{
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (stub *stubProvider) provider() *OIDCProvider{
+	return &OIDCProvider{
+		Name: "stub",
+		Issuer: stub.server.URL,
+		ClientId: "client",
+		RedirectURL: "http://localhost/callback",
+		Scopes: []string{"openid", "email"},
+		HTTPClient: stub.server.Client(),
+	}
+}
+
+// Starts the flow like the login handler and records what the provider receives from the browser
+func (stub *stubProvider) authorize(t *testing.T, provider *OIDCProvider, nonce string) string{
+	t.Helper()
+	verifier, challenge, err := GeneratePKCE()
+	if err != nil{
+		t.Fatal(err)
+	}
+	authorizationURL, err := provider.AuthorizationURL("state", nonce, challenge)
+	if err != nil{
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil{
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("state") != "state"{
+		t.Fatalf("unexpected authorization url %s", authorizationURL)
+	}
+	stub.mutex.Lock()
+	stub.challenge = query.Get("code_challenge")
+	stub.nonce = query.Get("nonce")
+	stub.mutex.Unlock()
+	return verifier
+}
+
+func TestGeneratePKCE(t *testing.T){
+	verifier, challenge, err := GeneratePKCE()
+	if err != nil{
+		t.Fatal(err)
+	}
+	// RFC 7636 verifier is 43 to 128 unreserved characters
+	if len(verifier) < 43 || len(verifier) > 128{
+		t.Fatalf("verifier of %d characters", len(verifier))
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	if challenge != base64.RawURLEncoding.EncodeToString(sum[:]){
+		t.Fatalf("challenge %s isn't S256 of verifier", challenge)
+	}
+	otherVerifier, _, _ := GeneratePKCE()
+	if otherVerifier == verifier{
+		t.Fatal("expected a new verifier every time")
+	}
+
+	// RFC 7636 appendix B
+	sum = sha256.Sum256([]byte("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+	if got := base64.RawURLEncoding.EncodeToString(sum[:]); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"{
+		t.Fatalf("unexpected challenge %s", got)
+	}
+}
+
+func TestOIDCExchange(t *testing.T){
+	stub := newStubProvider(t)
+
+	t.Run("valid code and verifier", func(t *testing.T){
+		provider := stub.provider()
+		verifier := stub.authorize(t, provider, "nonce")
+		identity, err := provider.Exchange("code", verifier, "nonce")
+		if err != nil{
+			t.Fatal(err)
+		}
+		if identity.Subject != "subject" || identity.Email != "user@example.com" || !identity.EmailVerified{
+			t.Fatalf("unexpected identity %+v", identity)
+		}
+	})
+
+	t.Run("verifier of another flow", func(t *testing.T){
+		provider := stub.provider()
+		stub.authorize(t, provider, "nonce")
+		otherVerifier, _, _ := GeneratePKCE()
+		if _, err := provider.Exchange("code", otherVerifier, "nonce"); err == nil{
+			t.Fatal("expected provider to reject the verifier")
+		}
+	})
+
+	t.Run("nonce of another flow", func(t *testing.T){
+		provider := stub.provider()
+		verifier := stub.authorize(t, provider, "nonce")
+		if _, err := provider.Exchange("code", verifier, "other"); err == nil{
+			t.Fatal("expected nonce mismatch")
+		}
+	})
+
+	t.Run("id token of another client", func(t *testing.T){
+		provider := stub.provider()
+		verifier := stub.authorize(t, provider, "nonce")
+		setAudience := func(audience string){
+			stub.mutex.Lock()
+			stub.audience = audience
+			stub.mutex.Unlock()
+		}
+		setAudience("other")
+		defer setAudience("client")
+		if _, err := provider.Exchange("code", verifier, "nonce"); err == nil{
+			t.Fatal("expected audience to be rejected")
+		}
+	})
+}
+
+func TestOIDCJWKSRefetchCooldown(t *testing.T){
+	stub := newStubProvider(t)
+	provider := stub.provider()
+	fetches := func() int{
+		stub.mutex.Lock()
+		defer stub.mutex.Unlock()
+		return stub.jwksFetches
+	}
+
+	if _, err := provider.getKey("stub"); err != nil{
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++{
+		if _, err := provider.getKey("unknown"); err == nil{
+			t.Fatal("expected unknown kid to be rejected")
+		}
+	}
+	if fetches() != 1{
+		t.Fatalf("expected a single fetch during cooldown, got %d", fetches())
+	}
+
+	// Once the cooldown passed an unknown kid refetches, the provider may have rotated keys
+	provider.keysFetchedOn = time.Now().Add(-OIDCJWKSREFETCHINTERVAL)
+	provider.getKey("unknown")
+	if fetches() != 2{
+		t.Fatalf("expected refetch after cooldown, got %d fetches", fetches())
+	}
+	if _, err := provider.getKey("stub"); err != nil || fetches() != 2{
+		t.Fatalf("expected known kid from cache, got %v after %d fetches", err, fetches())
+	}
+}
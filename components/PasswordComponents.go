@@ -0,0 +1,135 @@
+package components
+
+import (
+	_ "embed"
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Small built in list of the most common passwords
+//go:embed commonPasswords.txt
+var defaultCommonPasswords string
+
+// bcrypt ignores everything after 72 bytes
+const MAXPASSWORDBYTES = 72
+
+type PasswordPolicy struct{
+	MinLength int
+	BcryptCost int
+	CommonPasswords map[string]bool
+}
+
+// Loaded once on startup, defaults are used until then
+var passwordPolicy = newDefaultPasswordPolicy()
+
+func newDefaultPasswordPolicy() *PasswordPolicy{
+	return &PasswordPolicy{
+		MinLength: 10,
+		BcryptCost: bcrypt.DefaultCost,
+		CommonPasswords: parsePasswordList(defaultCommonPasswords),
+	}
+}
+
+func parsePasswordList(list string) map[string]bool{
+	passwords := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan(){
+		if password := strings.TrimSpace(scanner.Text()); password != ""{
+			passwords[strings.ToLower(password)] = true
+		}
+	}
+	return passwords
+}
+
+/*
+Loads password policy from environment variables
+	PASSWORD_MIN_LENGTH: minimum number of characters, 10 by default
+	PASSWORD_BLOCKLIST_FILE: file with one common or breached password per line, added to the built in list
+	BCRYPT_COST: cost of new password hashes, bcrypt default by default
+*/
+func LoadPasswordPolicy() error{
+	policy := newDefaultPasswordPolicy()
+
+	if value := os.Getenv("PASSWORD_MIN_LENGTH"); value != ""{
+		minLength, err := strconv.Atoi(value)
+		if err != nil || minLength < 1{
+			return errors.New("invalid PASSWORD_MIN_LENGTH")
+		}
+		policy.MinLength = minLength
+	}
+
+	if value := os.Getenv("BCRYPT_COST"); value != ""{
+		cost, err := strconv.Atoi(value)
+		if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost{
+			return errors.New("invalid BCRYPT_COST")
+		}
+		policy.BcryptCost = cost
+	}
+
+	if path := os.Getenv("PASSWORD_BLOCKLIST_FILE"); path != ""{
+		data, err := os.ReadFile(path)
+		if err != nil{
+			return err
+		}
+		for password := range parsePasswordList(string(data)){
+			policy.CommonPasswords[password] = true
+		}
+	}
+
+	passwordPolicy = policy
+	return nil
+}
+
+func GetPasswordPolicy() *PasswordPolicy{
+	return passwordPolicy
+}
+
+/*
+Checks password against the policy
+Password can't contain email or username of the user
+*/
+func (policy *PasswordPolicy) Validate(password, email, username string) error{
+	if utf8.RuneCountInString(password) < policy.MinLength{
+		return errors.New("password should be at least " + strconv.Itoa(policy.MinLength) + " characters long")
+	}
+	if len(password) > MAXPASSWORDBYTES{
+		return errors.New("password should be at most " + strconv.Itoa(MAXPASSWORDBYTES) + " bytes long")
+	}
+
+	lowered := strings.ToLower(password)
+	if policy.CommonPasswords[lowered]{
+		return errors.New("password is too common")
+	}
+
+	// Parts of the identity that are easy to guess
+	guessable := []string{strings.ToLower(email), strings.ToLower(username)}
+	if at := strings.Index(email, "@"); at > 0{
+		guessable = append(guessable, strings.ToLower(email[:at]))
+	}
+	for _, part := range guessable{
+		if len(part) >= 3 && strings.Contains(lowered, part){
+			return errors.New("password should not contain your email or username")
+		}
+	}
+	return nil
+}
+
+func HashPassword(password string)(string, error){
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), passwordPolicy.BcryptCost)
+	return string(hashedPassword), err
+}
+
+/*
+Whether hash was made with lower cost than currently configured
+Such hashes are replaced on the next successful sign in
+*/
+func NeedsRehash(hashedPassword string) bool{
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	return err == nil && cost < passwordPolicy.BcryptCost
+}
@@ -0,0 +1,171 @@
+package components
+
+import (
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const(
+	IDENTITYKEY string = "Identity"
+)
+
+type Permission string
+
+const (
+	PERMPLLREAD Permission = "pll:read"
+	PERMPLLWRITE Permission = "pll:write"
+	PERMPLLLIKE Permission = "pll:like"
+	PERMPLLMODERATE Permission = "pll:moderate"
+	PERMCOMMENTREAD Permission = "comment:read"
+	PERMCOMMENTWRITE Permission = "comment:write"
+	PERMCOMMENTMODERATE Permission = "comment:moderate"
+	PERMCATEGORYREAD Permission = "category:read"
+	PERMCATEGORYWRITE Permission = "category:write"
+	PERMUSERREAD Permission = "user:read"
+	PERMUSERROLE Permission = "user:role"
+	PERMUSERMANAGE Permission = "user:manage"
+	PERMUSERFOLLOW Permission = "user:follow"
+	PERMAUDITREAD Permission = "audit:read"
+	PERMUSERIMPERSONATE Permission = "user:impersonate"
+	PERMACCOUNTMANAGE Permission = "account:manage"
+	PERMACCOUNTSECURITY Permission = "account:security"
+)
+
+var userPermissions = []Permission{
+	PERMPLLREAD,
+	PERMPLLWRITE,
+	PERMPLLLIKE,
+	PERMCOMMENTREAD,
+	PERMCOMMENTWRITE,
+	PERMCATEGORYREAD,
+	PERMUSERFOLLOW,
+	PERMACCOUNTMANAGE,
+	PERMACCOUNTSECURITY,
+}
+
+var moderatorPermissions = append([]Permission{
+	PERMCOMMENTMODERATE,
+	PERMPLLMODERATE,
+}, userPermissions...)
+
+var adminPermissions = append([]Permission{
+	PERMCATEGORYWRITE,
+	PERMUSERREAD,
+	PERMUSERROLE,
+	PERMUSERMANAGE,
+	PERMAUDITREAD,
+	PERMUSERIMPERSONATE,
+}, moderatorPermissions...)
+
+// Permissions an api key can be restricted to
+// Account management is never available to api keys
+var ApiKeyScopes = []Permission{
+	PERMPLLREAD,
+	PERMPLLWRITE,
+	PERMPLLLIKE,
+	PERMCOMMENTREAD,
+	PERMCOMMENTWRITE,
+	PERMCATEGORYREAD,
+}
+
+func IsApiKeyScope(scope string) bool{
+	for _, permission := range ApiKeyScopes{
+		if string(permission) == scope{
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions withheld until the user verifies their email
+var unverifiedRestrictedPermissions = []Permission{
+	PERMPLLWRITE,
+}
+
+// Permissions withheld while an admin impersonates the user,
+// e.g. changing password, deleting the account or minting api keys
+var impersonationRestrictedPermissions = []Permission{
+	PERMACCOUNTSECURITY,
+	PERMUSERIMPERSONATE,
+}
+
+// Permissions granted to each role
+var RolePermissions = map[models.Role][]Permission{
+	models.ROLEUSER: userPermissions,
+	models.ROLEMODERATOR: moderatorPermissions,
+	models.ROLEADMIN: adminPermissions,
+}
+
+// Authenticated caller of the request
+type Identity struct{
+	UserId string
+	SessionId string
+	ApiKeyId string
+	ActorId string
+	Role models.Role
+	Verified bool
+	Permissions map[Permission]bool
+}
+
+func NewIdentity(userId, sessionId string, role models.Role, verified bool) *Identity{
+	permissions := make(map[Permission]bool)
+	for _, permission := range RolePermissions[role]{
+		permissions[permission] = true
+	}
+	if !verified{
+		for _, permission := range unverifiedRestrictedPermissions{
+			delete(permissions, permission)
+		}
+	}
+	return &Identity{
+		UserId: userId,
+		SessionId: sessionId,
+		Role: role,
+		Verified: verified,
+		Permissions: permissions,
+	}
+}
+
+/*
+Identity of a request made with an api key
+Key only gets the scopes it was created with, and only if its owner still has them
+*/
+func NewApiKeyIdentity(userId, apiKeyId string, role models.Role, verified bool, scopes []string) *Identity{
+	identity := NewIdentity(userId, "", role, verified)
+	identity.ApiKeyId = apiKeyId
+	permissions := make(map[Permission]bool)
+	for _, scope := range scopes{
+		if IsApiKeyScope(scope) && identity.Permissions[Permission(scope)]{
+			permissions[Permission(scope)] = true
+		}
+	}
+	identity.Permissions = permissions
+	return identity
+}
+
+/*
+Identity of a request made by admin @actorId impersonating the user
+Session is the one of the admin, so impersonation ends when the admin signs out
+*/
+func NewImpersonationIdentity(userId, actorId, sessionId string, role models.Role, verified bool) *Identity{
+	identity := NewIdentity(userId, sessionId, role, verified)
+	identity.ActorId = actorId
+	for _, permission := range impersonationRestrictedPermissions{
+		delete(identity.Permissions, permission)
+	}
+	return identity
+}
+
+func (identity *Identity) Can(permission Permission) bool{
+	return identity.Permissions[permission]
+}
+
+// Returns identity placed on the context by the authorizer, nil for unauthenticated requests
+func GetIdentity(c *gin.Context) *Identity{
+	identity, exists := c.Get(IDENTITYKEY)
+	if !exists{
+		return nil
+	}
+	return identity.(*Identity)
+}
@@ -0,0 +1,53 @@
+package components
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var pseudonymAdjectives = []string{
+	"Quiet", "Brave", "Gentle", "Curious", "Patient", "Humble", "Restless", "Wise",
+	"Hopeful", "Silent", "Steady", "Wandering", "Kind", "Bold", "Calm", "Honest",
+}
+
+var pseudonymNouns = []string{
+	"Owl", "Fox", "River", "Willow", "Sparrow", "Mountain", "Otter", "Cedar",
+	"Heron", "Comet", "Harbor", "Lantern", "Meadow", "Falcon", "Pine", "Tide",
+}
+
+/*
+Key of pseudonyms from PSEUDONYM_SECRET
+Kept apart from JWT_SECRET, rotating signing secrets mustn't rename every anonymous author
+*/
+func GetPseudonymSecret()([]byte, error){
+	secret := os.Getenv("PSEUDONYM_SECRET")
+	if secret == ""{
+		return nil, errors.New("no pseudonym secret found")
+	}
+	if secret == os.Getenv("JWT_SECRET"){
+		return nil, errors.New("pseudonym secret must differ from JWT_SECRET")
+	}
+	return []byte(secret), nil
+}
+
+/*
+Name shown instead of the author on anonymous posts, e.g. "Anonymous Quiet Owl #1a2b"
+Same user always gets the same pseudonym, but it can't be traced back without the secret
+*/
+func GetPseudonym(userId string)(string, error){
+	secret, err := GetPseudonymSecret()
+	if err != nil{
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("pseudonym:" + userId))
+	sum := mac.Sum(nil)
+
+	adjective := pseudonymAdjectives[int(sum[0])%len(pseudonymAdjectives)]
+	noun := pseudonymNouns[int(sum[1])%len(pseudonymNouns)]
+	return fmt.Sprintf("Anonymous %s %s #%04x", adjective, noun, binary.BigEndian.Uint16(sum[2:4])), nil
+}
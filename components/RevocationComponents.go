@@ -0,0 +1,94 @@
+package components
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+	"rest-api/models"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Keeps ids of access tokens revoked before their expiry
+Entries are dropped once the token would have expired anyway
+*/
+type RevocationStore interface{
+	Revoke(tokenId string, expiresOn time.Time) error
+	IsRevoked(tokenId string) (bool, error)
+}
+
+// How often expired entries are swept from memory store
+const REVOCATIONSWEEPINTERVAL = time.Minute
+
+/*
+Keeps revoked tokens in memory of a single server
+Revocations are lost on restart and not shared between instances
+*/
+type MemoryRevocationStore struct{
+	mutex sync.Mutex
+	revoked map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore{
+	return &MemoryRevocationStore{revoked: make(map[string]time.Time)}
+}
+
+func (store *MemoryRevocationStore) Revoke(tokenId string, expiresOn time.Time) error{
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(store.lastSweep) > REVOCATIONSWEEPINTERVAL{
+		for id, expiry := range store.revoked{
+			if !now.Before(expiry){
+				delete(store.revoked, id)
+			}
+		}
+		store.lastSweep = now
+	}
+	store.revoked[tokenId] = expiresOn
+	return nil
+}
+
+func (store *MemoryRevocationStore) IsRevoked(tokenId string)(bool, error){
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	expiresOn, ok := store.revoked[tokenId]
+	return ok && time.Now().Before(expiresOn), nil
+}
+
+// Keeps revoked tokens in mongo, shared by every instance
+type MongoRevocationStore struct{
+	coll *mongo.Collection
+}
+
+func NewMongoRevocationStore(coll *mongo.Collection) *MongoRevocationStore{
+	return &MongoRevocationStore{coll: coll}
+}
+
+func (store *MongoRevocationStore) Revoke(tokenId string, expiresOn time.Time) error{
+	return models.AddRevokedToken(tokenId, expiresOn, store.coll)
+}
+
+func (store *MongoRevocationStore) IsRevoked(tokenId string)(bool, error){
+	return models.IsTokenRevoked(tokenId, store.coll)
+}
+
+/*
+Selects revocation store from environment variable
+	REVOCATION_STORE: "mongo" (default) or "memory"
+*/
+func NewRevocationStoreFromEnv(coll *mongo.Collection)(RevocationStore, error){
+	switch os.Getenv("REVOCATION_STORE"){
+	case "", "mongo":
+		return NewMongoRevocationStore(coll), nil
+	case "memory":
+		return NewMemoryRevocationStore(), nil
+	default:
+		return nil, errors.New("unknown REVOCATION_STORE " + os.Getenv("REVOCATION_STORE"))
+	}
+}
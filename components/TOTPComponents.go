@@ -0,0 +1,113 @@
+package components
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 defaults, understood by every authenticator app
+const(
+	TOTPPERIOD int64 = 30
+	TOTPDIGITS int = 6
+	// Number of periods before and after the current one accepted for clock drift
+	TOTPSKEW int64 = 1
+	RECOVERYCODECOUNT int = 10
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Generates random base32 secret shared with the authenticator app
+func GenerateTOTPSecret()(string, error){
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil{
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(buf), nil
+}
+
+// URI encoded in the QR code scanned by authenticator apps
+func TOTPURI(issuer, account, secret string) string{
+	label := url.PathEscape(issuer + ":" + account)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDIGITS))
+	query.Set("period", fmt.Sprint(TOTPPERIOD))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Code of the given time step
+func totpCode(secret string, step int64)(string, error){
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil{
+		return "", err
+	}
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < TOTPDIGITS; i++{
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDIGITS, value%modulo), nil
+}
+
+/*
+Validates code against the secret at time @now
+Returns time step the code belongs to, so that it can't be used twice
+*/
+func ValidateTOTP(secret, code string, now time.Time)(int64, bool){
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDIGITS{
+		return 0, false
+	}
+	current := now.Unix() / TOTPPERIOD
+	for step := current - TOTPSKEW; step <= current + TOTPSKEW; step++{
+		expected, err := totpCode(secret, step)
+		if err != nil{
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)){
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Recovery codes are shown once to the user and only their hashes are stored
+func GenerateRecoveryCodes()([]string, error){
+	codes := make([]string, 0, RECOVERYCODECOUNT)
+	for i := 0; i < RECOVERYCODECOUNT; i++{
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil{
+			return nil, err
+		}
+		code := strings.ToLower(base32NoPadding.EncodeToString(buf))
+		codes = append(codes, code[:4] + "-" + code[4:])
+	}
+	return codes, nil
+}
+
+// Recovery codes are compared ignoring case and separators
+func NormalizeRecoveryCode(code string) string{
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	if len(code) == 8{
+		code = code[:4] + "-" + code[4:]
+	}
+	return code
+}
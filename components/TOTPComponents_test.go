@@ -0,0 +1,84 @@
+package components
+
+import (
+	"testing"
+	"time"
+)
+
+// Secret of the RFC 6238 SHA-1 test vectors, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 appendix B lists 8 digit codes, 6 digit codes are their last six digits
+func TestTOTPCodeRFCVectors(t *testing.T){
+	tests := []struct{
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, test := range tests{
+		code, err := totpCode(rfcSecret, test.unix / TOTPPERIOD)
+		if err != nil{
+			t.Fatal(err)
+		}
+		if code != test.code{
+			t.Errorf("at %d expected %s, got %s", test.unix, test.code, code)
+		}
+		if _, ok := ValidateTOTP(rfcSecret, test.code, time.Unix(test.unix, 0)); !ok{
+			t.Errorf("at %d expected %s to be valid", test.unix, test.code)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T){
+	// Code of step 37037036, valid from 1111111080 until 1111111109
+	const code = "081804"
+	const step int64 = 37037036
+
+	tests := []struct{
+		name string
+		unix int64
+		valid bool
+	}{
+		{"two periods early", 1111111020, false},
+		{"last second before previous period", 1111111049, false},
+		{"one period early", 1111111050, true},
+		{"first second of period", 1111111080, true},
+		{"last second of period", 1111111109, true},
+		{"one period late", 1111111139, true},
+		{"first second after next period", 1111111140, false},
+		{"two periods late", 1111111170, false},
+	}
+	for _, test := range tests{
+		t.Run(test.name, func(t *testing.T){
+			matched, ok := ValidateTOTP(rfcSecret, code, time.Unix(test.unix, 0))
+			if ok != test.valid{
+				t.Fatalf("expected valid %t, got %t", test.valid, ok)
+			}
+			// Step of the code is returned whichever period it was accepted in
+			if ok && matched != step{
+				t.Fatalf("expected step %d, got %d", step, matched)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPMalformed(t *testing.T){
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082", "abcdef"}{
+		if _, ok := ValidateTOTP(rfcSecret, code, now); ok{
+			t.Errorf("expected %q to be rejected", code)
+		}
+	}
+	if _, ok := ValidateTOTP(rfcSecret, " 287082 ", now); !ok{
+		t.Error("expected surrounding spaces to be ignored")
+	}
+	if _, ok := ValidateTOTP("not base32!", "287082", now); ok{
+		t.Error("expected invalid secret to reject every code")
+	}
+}
@@ -0,0 +1,128 @@
+package components
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Generates random url safe token to be handed out to the client
+func GenerateOpaqueToken() (string, error){
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil{
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Opaque tokens are only stored as their hash
+func HashToken(token string) string{
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+Issues new access token and refresh token for the user
+Empty @sessionId starts a new session for the requesting device (i.e. a fresh login),
+otherwise the new refresh token replaces a used one of the same session
+*/
+func IssueTokens(c *gin.Context, user *models.User, sessionId string, sessionColl, refreshColl *mongo.Collection)(*models.TokenResponse, error){
+
+	// Starting new session for the device, or keeping the used one alive as long as the new refresh token
+	now := time.Now()
+	expiresOn := now.Add(REFRESHTOKENEXPIRY)
+	if sessionId == ""{
+		result, err := models.NewSessionIntermediate(user.ID, c.Request.UserAgent(), c.ClientIP(), expiresOn).AddSession(sessionColl)
+		if err != nil{
+			return nil, err
+		}
+		sessionId = result.InsertedID.(primitive.ObjectID).Hex()
+	}else if err := models.ExtendSession(sessionId, expiresOn, sessionColl); err != nil{
+		return nil, err
+	}
+
+	// Generating short lived access token
+	token, err := GenerateJWTToken(user.ID, sessionId)
+	if err != nil{
+		return nil, err
+	}
+
+	// Generating refresh token and saving its hash
+	// Refresh tokens of a session form a single family
+	refreshToken, err := GenerateOpaqueToken()
+	if err != nil{
+		return nil, err
+	}
+	refreshTokenIntermediate := models.RefreshTokenIntermediate{
+		UserId: user.ID,
+		FamilyId: sessionId,
+		TokenHash: HashToken(refreshToken),
+		CreatedOn: now,
+		ExpiresOn: expiresOn,
+	}
+	if _, err := refreshTokenIntermediate.AddRefreshToken(refreshColl); err != nil{
+		return nil, err
+	}
+
+	return &models.TokenResponse{
+		Token: token,
+		RefreshToken: refreshToken,
+		ExpiresIn: int64(ACCESSTOKENEXPIRY.Seconds()),
+		UserId: user.ID,
+	}, nil
+}
+
+/*
+Revokes the session along with every refresh token issued for it
+*/
+func RevokeSession(sessionId, userId string, sessionColl, refreshColl *mongo.Collection)(bool, error){
+	result, err := models.RevokeSession(sessionId, userId, sessionColl)
+	if err != nil{
+		return false, err
+	}
+	if result.MatchedCount == 0{
+		return false, nil
+	}
+	return true, models.RevokeRefreshTokenFamily(sessionId, refreshColl)
+}
+
+/*
+Signs the user out of every device
+*/
+func RevokeAllSessions(userId string, sessionColl, refreshColl *mongo.Collection) error{
+	if err := models.RevokeUserSessions(userId, sessionColl); err != nil{
+		return err
+	}
+	return models.RevokeUserRefreshTokens(userId, refreshColl)
+}
+
+/*
+Generates one time token for @purpose and saves its hash
+Returned token is the one to be sent to the user
+*/
+func IssueOneTimeToken(userId string, purpose models.TokenPurpose, expiry time.Duration, data map[string]string, tokenColl *mongo.Collection)(string, error){
+	token, err := GenerateOpaqueToken()
+	if err != nil{
+		return "", err
+	}
+	now := time.Now()
+	oneTimeToken := models.OneTimeTokenIntermediate{
+		UserId: userId,
+		Purpose: purpose,
+		TokenHash: HashToken(token),
+		Data: data,
+		CreatedOn: now,
+		ExpiresOn: now.Add(expiry),
+	}
+	if _, err := oneTimeToken.AddOneTimeToken(tokenColl); err != nil{
+		return "", err
+	}
+	return token, nil
+}
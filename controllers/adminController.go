@@ -0,0 +1,353 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+	"rest-api/components"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Promotes or demotes a user, every change is recorded
+*/
+func UpdateUserRoleHandler(userColl, roleChangeColl, auditColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving role change request from body
+		var request models.RoleChangeRequest
+		if err := c.BindJSON(&request); err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if !request.Role.IsValid(){
+			c.JSON(http.StatusBadRequest, gin.H{"message":"role should be one of user, moderator or admin"})
+			c.Abort()
+			return
+		}
+
+		// Admins can't change their own role so that there is always an admin left
+		adminId := c.GetString(components.USERIDKEY)
+		if adminId == request.UserId{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"cannot change your own role"})
+			c.Abort()
+			return
+		}
+
+		// Retrieving user whose role is to be changed
+		user, err := models.GetUserById(request.UserId, userColl)
+		if err != nil{
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+		previousRole := user.GetRole()
+		if previousRole == request.Role{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"user already has role " + string(request.Role)})
+			c.Abort()
+			return
+		}
+
+		// Updating the role, takes effect on the next request of the user
+		if _, err := models.UpdateUserRole(user.ID, request.Role, userColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Recording the change
+		if _, err := request.ToRoleChangeIntermediate(adminId, previousRole).AddRoleChange(roleChangeColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		components.RecordAuditEvent(c, models.EVENTROLECHANGE, models.OUTCOMESUCCESS, user.ID, map[string]string{"from": string(previousRole), "to": string(request.Role)}, auditColl)
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully changed role to " + string(request.Role)})
+	}
+}
+
+/*
+Optional Query (userId: userId)
+*/
+func GetRoleChangesHandler(roleChangeColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		roleChanges, err := models.GetRoleChanges(c.Query("userId"), roleChangeColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, roleChanges)
+	}
+}
+
+
+/*
+Lifts the lockout caused by failed sign in attempts of a user
+*/
+func UnlockUserHandler(userColl, attemptColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving user id from request body
+		var body struct{
+			UserId string `json:"userId"`
+		}
+		if err := c.BindJSON(&body); err != nil || body.UserId == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"userId not provided"})
+			c.Abort()
+			return
+		}
+
+		user, err := models.GetUserById(body.UserId, userColl)
+		if err != nil{
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+
+		// Clearing password and second factor attempts
+		if err := clearAccountLockout(user, attemptColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully unlocked user"})
+	}
+}
+
+/*
+Temporarily stops a user from using their account
+Sessions are kept, so the user can continue once the suspension ends
+*/
+func SuspendUserHandler(userColl, auditColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving suspension request from body
+		var request models.SuspensionRequest
+		if err := c.BindJSON(&request); err != nil || request.UserId == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"userId and until are required"})
+			c.Abort()
+			return
+		}
+		if !request.Until.After(time.Now()){
+			c.JSON(http.StatusBadRequest, gin.H{"message":"until should be in the future"})
+			c.Abort()
+			return
+		}
+
+		adminId := c.GetString(components.USERIDKEY)
+		if adminId == request.UserId{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"cannot suspend yourself"})
+			c.Abort()
+			return
+		}
+
+		// Admins can only be restricted after being demoted
+		user, err := models.GetUserById(request.UserId, userColl)
+		if err != nil{
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+		if user.GetRole() == models.ROLEADMIN{
+			c.JSON(http.StatusForbidden, gin.H{"message":"cannot suspend an admin"})
+			c.Abort()
+			return
+		}
+
+		result, err := models.SuspendUser(&request, adminId, userColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if result.MatchedCount == 0{
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+		components.RecordAuditEvent(c, models.EVENTACCOUNTRESTRICTION, models.OUTCOMESUCCESS, request.UserId, map[string]string{"action": "suspend", "until": request.Until.UTC().Format(time.RFC3339), "reason": request.Reason}, auditColl)
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully suspended user until " + request.Until.UTC().Format(time.RFC3339)})
+	}
+}
+
+/*
+Permanently stops a user from using their account and signs them out everywhere
+Lessons and comments of the user are hidden when hideContent is set
+*/
+func BanUserHandler(userColl, sessionColl, refreshColl, apiKeyColl, auditColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving ban request from body
+		var request models.BanRequest
+		if err := c.BindJSON(&request); err != nil || request.UserId == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"userId not provided"})
+			c.Abort()
+			return
+		}
+
+		adminId := c.GetString(components.USERIDKEY)
+		if adminId == request.UserId{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"cannot ban yourself"})
+			c.Abort()
+			return
+		}
+
+		// Admins can only be restricted after being demoted
+		user, err := models.GetUserById(request.UserId, userColl)
+		if err != nil{
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+		if user.GetRole() == models.ROLEADMIN{
+			c.JSON(http.StatusForbidden, gin.H{"message":"cannot ban an admin"})
+			c.Abort()
+			return
+		}
+
+		result, err := models.BanUser(&request, adminId, userColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if result.MatchedCount == 0{
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+
+		// Banned users don't come back, so their sessions are ended right away
+		if err := components.RevokeAllSessions(request.UserId, sessionColl, refreshColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if err := models.RevokeUserApiKeys(request.UserId, apiKeyColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		components.RecordAuditEvent(c, models.EVENTACCOUNTRESTRICTION, models.OUTCOMESUCCESS, request.UserId, map[string]string{"action": "ban", "reason": request.Reason}, auditColl)
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully banned user"})
+	}
+}
+
+/*
+Lifts suspension or ban of a user
+*/
+func ReinstateUserHandler(userColl, auditColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving user id from request body
+		var body struct{
+			UserId string `json:"userId"`
+		}
+		if err := c.BindJSON(&body); err != nil || body.UserId == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"userId not provided"})
+			c.Abort()
+			return
+		}
+
+		result, err := models.ReinstateUser(body.UserId, userColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if result.MatchedCount == 0{
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+		components.RecordAuditEvent(c, models.EVENTACCOUNTRESTRICTION, models.OUTCOMESUCCESS, body.UserId, map[string]string{"action": "reinstate"}, auditColl)
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully reinstated user"})
+	}
+}
+
+// Impersonation tokens are short lived and never refreshed
+const (
+	IMPERSONATIONEXPIRY = time.Minute*15
+	IMPERSONATIONMAXEXPIRY = time.Hour
+)
+
+/*
+Mints token letting the admin see the app as the given user
+Dangerous actions like changing password or deleting the account are blocked for it,
+and every request made with it is recorded in the audit log
+*/
+func ImpersonateUserHandler(userColl, auditColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving impersonation request from body
+		var body struct{
+			UserId string `json:"userId"`
+			Reason string `json:"reason"`
+			Minutes int `json:"minutes"`
+		}
+		if err := c.BindJSON(&body); err != nil || body.UserId == "" || body.Reason == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"userId and reason are required"})
+			c.Abort()
+			return
+		}
+		expiry := IMPERSONATIONEXPIRY
+		if body.Minutes > 0{
+			expiry = time.Duration(body.Minutes)*time.Minute
+		}
+		if expiry > IMPERSONATIONMAXEXPIRY{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"impersonation can last at most " + IMPERSONATIONMAXEXPIRY.String()})
+			c.Abort()
+			return
+		}
+
+		// Impersonation can't be chained or used on admins
+		identity := components.GetIdentity(c)
+		if identity.ActorId != "" || identity.SessionId == ""{
+			c.JSON(http.StatusForbidden, gin.H{"message":"impersonation requires a regular sign in"})
+			c.Abort()
+			return
+		}
+		if identity.UserId == body.UserId{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"cannot impersonate yourself"})
+			c.Abort()
+			return
+		}
+		user, err := models.GetUserById(body.UserId, userColl)
+		if err != nil{
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+		if user.GetRole() == models.ROLEADMIN{
+			c.JSON(http.StatusForbidden, gin.H{"message":"cannot impersonate an admin"})
+			c.Abort()
+			return
+		}
+		if user.IsPendingDeletion(){
+			c.JSON(http.StatusForbidden, gin.H{"message":"cannot impersonate an account scheduled for deletion"})
+			c.Abort()
+			return
+		}
+
+		// Token is bound to the session of the admin
+		token, err := components.GenerateImpersonationToken(user.ID, identity.UserId, identity.SessionId, expiry)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		components.RecordAuditEvent(c, models.EVENTIMPERSONATION, models.OUTCOMESUCCESS, user.ID, map[string]string{"reason": body.Reason, "expiresIn": expiry.String()}, auditColl)
+		c.JSON(http.StatusOK, gin.H{
+			"token": token,
+			"expiresIn": int64(expiry.Seconds()),
+			"userId": user.ID,
+			"impersonating": true,
+			"actorId": identity.UserId,
+		})
+	}
+}
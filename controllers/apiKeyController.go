@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"net/http"
+	"rest-api/components"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Creates named api key restricted to the requested scopes
+Key is returned only once, only its hash is stored
+*/
+func AddApiKeyHandler(apiKeyColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving api key request from body
+		var request models.ApiKeyRequest
+		if err := c.BindJSON(&request); err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if request.Name == "" || len(request.Scopes) == 0{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"name and at least one scope are required"})
+			c.Abort()
+			return
+		}
+		for _, scope := range request.Scopes{
+			if !components.IsApiKeyScope(scope){
+				c.JSON(http.StatusBadRequest, gin.H{"message":"invalid scope " + scope})
+				c.Abort()
+				return
+			}
+		}
+
+		// Generating the key
+		secret, err := components.GenerateOpaqueToken()
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		key := components.APIKEYPREFIX + secret
+
+		// Prefix lets the user tell keys apart without revealing them
+		userId := c.GetString(components.USERIDKEY)
+		prefix := key[:len(components.APIKEYPREFIX)+6]
+		result, err := request.ToApiKeyIntermediate(userId, prefix, components.HashToken(key)).AddApiKey(apiKeyColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"_id": result.InsertedID, "key": key, "prefix": prefix, "scopes": request.Scopes})
+	}
+}
+
+func GetApiKeysHandler(apiKeyColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		apiKeys, err := models.GetApiKeys(c.GetString(components.USERIDKEY), apiKeyColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, apiKeys)
+	}
+}
+
+/*
+Requires Query (id: apiKeyId)
+*/
+func RevokeApiKeyHandler(apiKeyColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		apiKeyId := c.Query("id")
+		if apiKeyId == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"unable to find 'id' in query"})
+			c.Abort()
+			return
+		}
+
+		// Only keys of the requesting user can be revoked
+		result, err := models.RevokeApiKey(apiKeyId, c.GetString(components.USERIDKEY), apiKeyColl)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if result.MatchedCount == 0{
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such api key found"})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully revoked api key"})
+	}
+}
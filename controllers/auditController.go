@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"rest-api/components"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Number of events shown to users in their recent security activity
+const SECURITYACTIVITYLIMIT int64 = 50
+
+/*
+Optional Query (type: event type, from: RFC3339 time, to: RFC3339 time, limit: number of events)
+*/
+func parseAuditEventFilter(c *gin.Context)(*models.AuditEventFilter, error){
+	filter := models.AuditEventFilter{Type: models.AuditEventType(c.Query("type"))}
+	if from := c.Query("from"); from != ""{
+		parsed, err := time.Parse(time.RFC3339, from)
+		if err != nil{
+			return nil, errors.New("'from' should be an RFC3339 time")
+		}
+		filter.From = parsed
+	}
+	if to := c.Query("to"); to != ""{
+		parsed, err := time.Parse(time.RFC3339, to)
+		if err != nil{
+			return nil, errors.New("'to' should be an RFC3339 time")
+		}
+		filter.To = parsed
+	}
+	if limit := c.Query("limit"); limit != ""{
+		parsed, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || parsed < 1{
+			return nil, errors.New("'limit' should be a positive number")
+		}
+		filter.Limit = parsed
+	}
+	return &filter, nil
+}
+
+/*
+Optional Query (userId: userId, type, from, to, limit)
+Returns audit events of every user, latest first
+*/
+func GetAuditEventsHandler(auditColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		filter, err := parseAuditEventFilter(c)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		filter.UserId = c.Query("userId")
+
+		events, err := models.GetAuditEvents(filter, auditColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, events)
+	}
+}
+
+/*
+Optional Query (type, from, to, limit)
+Recent security activity of the requesting user, e.g. sign ins and password changes
+*/
+func GetSecurityActivityHandler(auditColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		filter, err := parseAuditEventFilter(c)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		filter.UserId = c.GetString(components.USERIDKEY)
+		if filter.Limit == 0 || filter.Limit > SECURITYACTIVITYLIMIT{
+			filter.Limit = SECURITYACTIVITYLIMIT
+		}
+
+		events, err := models.GetAuditEvents(filter, auditColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, events)
+	}
+}
@@ -129,13 +129,14 @@ func DeleteCommentHandler(pllColl, commentColl *mongo.Collection)gin.HandlerFunc
 		}
 
 		// Is given User authorized to delete this comment
+		// Moderators can delete any comment
 		authorized, err := components.CheckAuthority(userId.(string), commentId, components.COMMENT, commentColl)
 		if err != nil{
 			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
 			c.Abort()
 			return
 		}
-		if !authorized{
+		if !authorized && !components.GetIdentity(c).Can(components.PERMCOMMENTMODERATE){
 			c.JSON(http.StatusUnauthorized, gin.H{"message":"not authorized to delete this comment"})
 			c.Abort()
 			return
@@ -164,7 +165,7 @@ func DeleteCommentHandler(pllColl, commentColl *mongo.Collection)gin.HandlerFunc
 // 	}
 // }
 
-func GetCommentsHandler(pllColl, commentColl *mongo.Collection)gin.HandlerFunc{
+func GetCommentsHandler(pllColl, commentColl, userColl *mongo.Collection)gin.HandlerFunc{
 	return func(c *gin.Context){
 
 		// Retreiving pll id from query
@@ -184,8 +185,16 @@ func GetCommentsHandler(pllColl, commentColl *mongo.Collection)gin.HandlerFunc{
 			return 
 		}
 
+		// Comments of banned users may be hidden
+		hiddenUserIds, err := models.GetHiddenUserIds(userColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
 		// Extracting comments with associated comment id's slice
-		comments := models.GetComments(pll.Comments, commentColl)
+		comments := models.GetComments(pll.Comments, hiddenUserIds, commentColl)
 
 		c.JSON(http.StatusOK, comments)
 	}
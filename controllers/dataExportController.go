@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"rest-api/components"
+	"rest-api/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+)
+
+/*
+Starts assembling archive of everything tied to requesting user
+Download link valid for 24 hours is only mailed once the archive is ready,
+so a stolen access token alone isn't enough to get the archive
+*/
+func RequestDataExportHandler(exporter *components.DataExporter, tokenColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		userId := c.GetString(components.USERIDKEY)
+		user, err := models.GetUserById(userId, exporter.UserColl)
+		if err != nil{
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+
+		// Only one export is assembled at a time, one pending too long was interrupted
+		latest, err := models.GetLatestDataExport(userId, exporter.ExportColl)
+		if err == nil && latest.Status == models.EXPORTPENDING && time.Since(latest.RequestedOn) < components.DATAEXPORTTIMEOUT{
+			c.JSON(http.StatusConflict, gin.H{"message":"a data export is already being prepared", "exportId": latest.ID})
+			c.Abort()
+			return
+		}
+
+		now := time.Now()
+		export := models.DataExportIntermediate{
+			UserId: userId,
+			Status: models.EXPORTPENDING,
+			RequestedOn: now,
+			ExpiresOn: now.Add(components.DATAEXPORTEXPIRY),
+		}
+		result, err := export.AddDataExport(exporter.ExportColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		exportId := result.InsertedID.(primitive.ObjectID).Hex()
+
+		// Link keeps working until the export expires, downloads can be retried
+		token, err := components.IssueOneTimeToken(userId, models.PURPOSEDATAEXPORT, components.DATAEXPORTEXPIRY, map[string]string{"exportId": exportId}, tokenColl)
+		if err != nil{
+			if err := models.FailDataExport(exportId, "unable to issue download link", exporter.ExportColl); err != nil{
+				log.Println("Unable to mark data export", exportId, "as failed:", err.Error())
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		downloadLink := components.GetPublicURL() + "/v1/user/dataExport/download?token=" + url.QueryEscape(token)
+
+		go exporter.Export(exportId, user, downloadLink)
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": "Data export is being prepared, the download link will be mailed to you",
+			"exportId": exportId,
+			"expiresOn": export.ExpiresOn,
+		})
+	}
+}
+
+// Returns status of the latest data export of requesting user
+func GetDataExportHandler(exportColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		export, err := models.GetLatestDataExport(c.GetString(components.USERIDKEY), exportColl)
+		if err != nil{
+			c.JSON(http.StatusNotFound, gin.H{"message":"no data export found"})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusOK, export)
+	}
+}
+
+/*
+Requires Query (token: download token)
+Token is the authentication, so the link works from a plain browser
+*/
+func DownloadDataExportHandler(exportColl, tokenColl *mongo.Collection, archives *gridfs.Bucket) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving export the token was issued for
+		token := c.Query("token")
+		if token == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"unable to find 'token' in query"})
+			c.Abort()
+			return
+		}
+		downloadToken, err := models.GetOneTimeToken(components.HashToken(token), models.PURPOSEDATAEXPORT, tokenColl)
+		if err != nil{
+			c.JSON(http.StatusUnauthorized, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		export, err := models.GetDataExport(downloadToken.Data["exportId"], exportColl)
+		if err != nil || export.UserId != downloadToken.UserId{
+			c.JSON(http.StatusNotFound, gin.H{"message":"data export no longer exists"})
+			c.Abort()
+			return
+		}
+
+		switch export.Status{
+		case models.EXPORTPENDING:
+			c.JSON(http.StatusConflict, gin.H{"message":"data export is still being prepared"})
+			c.Abort()
+			return
+		case models.EXPORTFAILED:
+			c.JSON(http.StatusInternalServerError, gin.H{"message":"data export failed, request a new one"})
+			c.Abort()
+			return
+		}
+
+		archive, err := models.OpenDataExportArchive(export.ID, archives)
+		if err != nil{
+			c.JSON(http.StatusNotFound, gin.H{"message":"data export no longer exists"})
+			c.Abort()
+			return
+		}
+		defer archive.Close()
+
+		headers := map[string]string{
+			"Content-Disposition": "attachment; filename=\"data-export-" + export.RequestedOn.UTC().Format("2006-01-02") + ".zip\"",
+			"Cache-Control": "no-store",
+		}
+		c.DataFromReader(http.StatusOK, archive.GetFile().Length, "application/zip", archive, headers)
+	}
+}
@@ -0,0 +1,332 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+	"rest-api/components"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const(
+	EMAILCHANGETOKENEXPIRY = time.Hour*24
+	// Sessions signed in this recently may change email without proving themselves again
+	RECENTSIGNINWINDOW = time.Minute*10
+)
+
+/*
+Stolen access token alone must not be enough to take over the account
+The user proves themselves with their password, a current TOTP code, or by having signed in a moment ago,
+so users signing in through OIDC or magic links can do it too
+Guesses are limited by the caller through the lockout of the account
+*/
+func verifyRecentAuthentication(c *gin.Context, user *models.User, password, code string, userColl, sessionColl *mongo.Collection)(bool, error){
+	if password != ""{
+		return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil, nil
+	}
+	if code != ""{
+		if !user.TOTPEnabled{
+			return false, nil
+		}
+		step, ok := components.ValidateTOTP(user.TOTPSecret, code, time.Now())
+		if !ok{
+			return false, nil
+		}
+		return models.UseTOTPStep(user.ID, step, userColl)
+	}
+	sessionId := c.GetString(components.SESSIONIDKEY)
+	if sessionId == ""{
+		return false, nil
+	}
+	// Revoked or expired session proves nothing
+	session, err := models.GetActiveSession(sessionId, user.ID, sessionColl)
+	if err != nil{
+		return false, nil
+	}
+	return time.Since(session.CreatedOn) < RECENTSIGNINWINDOW, nil
+}
+
+/*
+Starts changing email of the requesting user
+Requires password or current TOTP code, unless the user signed in within RECENTSIGNINWINDOW
+Wrong answers count towards the same lockout as signing in
+Confirmation link is sent to the new email and a notice with cancel link to the old one,
+email stays the same until the change is confirmed
+*/
+func RequestEmailChangeHandler(userColl, sessionColl, tokenColl, attemptColl, auditColl *mongo.Collection, mailer components.Mailer) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving new email and proof of identity from request body
+		var body struct{
+			NewEmail string `json:"newEmail"`
+			Password string `json:"password"`
+			Code string `json:"code"`
+		}
+		if err := c.BindJSON(&body); err != nil || body.NewEmail == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"newEmail not provided"})
+			c.Abort()
+			return
+		}
+		body.NewEmail = models.NormalizeEmail(body.NewEmail)
+		if err := components.ValidateEmail(body.NewEmail); err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Retrieving user from token verification
+		user, err := models.GetUserById(c.GetString(components.USERIDKEY), userColl)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+
+		// Password and code are guessed no faster than when signing in
+		attemptKey := emailAttemptKey(user.Email)
+		if body.Password == "" && body.Code != ""{
+			attemptKey = mfaAttemptKey(user.ID)
+		}
+		if rejectIfLocked(c, attemptColl, attemptKey){
+			components.RecordAuditEvent(c, models.EVENTEMAILCHANGE, models.OUTCOMEFAILURE, user.ID, map[string]string{"action": "request", "reason": "locked"}, auditColl)
+			return
+		}
+
+		// Stolen access token alone is not enough to take over the account
+		verified, err := verifyRecentAuthentication(c, user, body.Password, body.Code, userColl, sessionColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if !verified{
+			// Session too old to change email without proof isn't a guess
+			if body.Password != "" || body.Code != ""{
+				if err := models.RecordLoginFailure(attemptKey, models.ACCOUNTLOCKOUT, attemptColl); err != nil{
+					log.Println("Unable to record failed email change:", err.Error())
+				}
+				components.RecordAuditEvent(c, models.EVENTEMAILCHANGE, models.OUTCOMEFAILURE, user.ID, map[string]string{"action": "request", "reason": "wrong password or code"}, auditColl)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"message":"confirm with your password or 2FA code, or sign in again"})
+			c.Abort()
+			return
+		}
+		if body.Password != "" || body.Code != ""{
+			models.ClearLoginAttempts(attemptKey, attemptColl)
+		}
+		if body.NewEmail == user.Email{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"new email is the same as the current one"})
+			c.Abort()
+			return
+		}
+		if _, err := models.GetUserByEmail(body.NewEmail, userColl); err == nil{
+			c.JSON(http.StatusConflict, gin.H{"message":"email already in use"})
+			c.Abort()
+			return
+		}
+
+		// Only the latest request can be confirmed or cancelled
+		for _, purpose := range []models.TokenPurpose{models.PURPOSEEMAILCHANGE, models.PURPOSEEMAILCHANGECANCEL}{
+			if err := models.InvalidateOneTimeTokens(user.ID, purpose, tokenColl); err != nil{
+				c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+				c.Abort()
+				return
+			}
+		}
+		confirmToken, err := components.IssueOneTimeToken(user.ID, models.PURPOSEEMAILCHANGE, EMAILCHANGETOKENEXPIRY, map[string]string{"oldEmail": user.Email, "newEmail": body.NewEmail}, tokenColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		cancelToken, err := components.IssueOneTimeToken(user.ID, models.PURPOSEEMAILCHANGECANCEL, EMAILCHANGETOKENEXPIRY, map[string]string{"newEmail": body.NewEmail}, tokenColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Sending confirmation link to the new email
+		confirmLink := components.GetAppURL() + "/confirmEmailChange?token=" + url.QueryEscape(confirmToken)
+		err = mailer.Send(components.Mail{
+			To: body.NewEmail,
+			Subject: "Confirm your new email",
+			Body: "Hi " + user.Username + ",\n\n" +
+				"Open the following link to start using this email for your account, it is valid for 24 hours:\n" +
+				confirmLink + "\n\n" +
+				"If you didn't ask for this, you can ignore this email.\n",
+		})
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":"unable to send confirmation email"})
+			c.Abort()
+			return
+		}
+
+		// Notifying the old email, failing to send is not fatal
+		cancelLink := components.GetAppURL() + "/cancelEmailChange?token=" + url.QueryEscape(cancelToken)
+		err = mailer.Send(components.Mail{
+			To: user.Email,
+			Subject: "Your email is about to change",
+			Body: "Hi " + user.Username + ",\n\n" +
+				"A change of your account email to " + body.NewEmail + " was requested.\n" +
+				"If you didn't ask for this, open the following link to cancel it, it signs you out everywhere, then change your password:\n" +
+				cancelLink + "\n",
+		})
+		if err != nil{
+			log.Println("Unable to send email change notice to", user.Email, ":", err.Error())
+		}
+
+		components.RecordAuditEvent(c, models.EVENTEMAILCHANGE, models.OUTCOMESUCCESS, user.ID, map[string]string{"action": "request", "newEmail": body.NewEmail}, auditColl)
+		c.JSON(http.StatusOK, gin.H{"message":"Confirmation link sent to " + body.NewEmail})
+	}
+}
+
+/*
+Switches the email using token from the confirmation link
+User is signed out everywhere and gets new tokens, like after a password change
+*/
+func ConfirmEmailChangeHandler(userColl, sessionColl, refreshColl, apiKeyColl, tokenColl, auditColl *mongo.Collection, mailer components.Mailer) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving confirmation token from request body
+		var body struct{
+			Token string `json:"token"`
+		}
+		if err := c.BindJSON(&body); err != nil || body.Token == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"token not provided"})
+			c.Abort()
+			return
+		}
+
+		// Consuming the confirmation token
+		changeToken, err := models.UseOneTimeToken(components.HashToken(body.Token), models.PURPOSEEMAILCHANGE, tokenColl)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		oldEmail, newEmail := changeToken.Data["oldEmail"], changeToken.Data["newEmail"]
+
+		user, err := models.GetUserById(changeToken.UserId, userColl)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+
+		// Email may have been taken since the change was requested
+		if _, err := models.GetUserByEmail(newEmail, userColl); err == nil{
+			c.JSON(http.StatusConflict, gin.H{"message":"email already in use"})
+			c.Abort()
+			return
+		}
+
+		// Switching the email, uniqueness is enforced by the index should it be taken meanwhile
+		result, err := models.ChangeUserEmail(user.ID, oldEmail, newEmail, userColl)
+		if mongo.IsDuplicateKeyError(err){
+			c.JSON(http.StatusConflict, gin.H{"message":"email already in use"})
+			c.Abort()
+			return
+		}
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if result.MatchedCount == 0{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"email has changed since the link was sent"})
+			c.Abort()
+			return
+		}
+		components.RecordAuditEvent(c, models.EVENTEMAILCHANGE, models.OUTCOMESUCCESS, user.ID, map[string]string{"action": "confirm", "oldEmail": oldEmail, "newEmail": newEmail}, auditColl)
+
+		// Links sent to the old email are no longer valid
+		for _, purpose := range []models.TokenPurpose{models.PURPOSEEMAILCHANGECANCEL, models.PURPOSEEMAILVERIFICATION, models.PURPOSEPASSWORDRESET}{
+			if err := models.InvalidateOneTimeTokens(user.ID, purpose, tokenColl); err != nil{
+				c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+				c.Abort()
+				return
+			}
+		}
+
+		// Signing the user out of every device
+		if err := components.RevokeAllSessions(user.ID, sessionColl, refreshColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if err := models.RevokeUserApiKeys(user.ID, apiKeyColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		err = mailer.Send(components.Mail{
+			To: oldEmail,
+			Subject: "Your email has been changed",
+			Body: "Hi " + user.Username + ",\n\n" +
+				"Your account email has been changed to " + newEmail + ".\n",
+		})
+		if err != nil{
+			log.Println("Unable to send email change notice to", oldEmail, ":", err.Error())
+		}
+
+		// Issuing new tokens, second factor is still asked for when enabled
+		user.Email, user.Verified = newEmail, true
+		signInUser(c, user, "emailChange", sessionColl, refreshColl, auditColl)
+	}
+}
+
+/*
+Opened from the link in the notice sent to the old email
+Someone else may be signed in, so the user is signed out everywhere and their api keys are revoked
+*/
+func CancelEmailChangeHandler(sessionColl, refreshColl, apiKeyColl, tokenColl, auditColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving cancel token from request body
+		var body struct{
+			Token string `json:"token"`
+		}
+		if err := c.BindJSON(&body); err != nil || body.Token == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"token not provided"})
+			c.Abort()
+			return
+		}
+
+		// Consuming the cancel token
+		cancelToken, err := models.UseOneTimeToken(components.HashToken(body.Token), models.PURPOSEEMAILCHANGECANCEL, tokenColl)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Confirmation link sent to the new email stops working
+		if err := models.InvalidateOneTimeTokens(cancelToken.UserId, models.PURPOSEEMAILCHANGE, tokenColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Whoever requested the change loses access
+		if err := components.RevokeAllSessions(cancelToken.UserId, sessionColl, refreshColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if err := models.RevokeUserApiKeys(cancelToken.UserId, apiKeyColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		components.RecordAuditEvent(c, models.EVENTEMAILCHANGE, models.OUTCOMESUCCESS, cancelToken.UserId, map[string]string{"action": "cancel", "newEmail": cancelToken.Data["newEmail"]}, auditColl)
+		c.JSON(http.StatusOK, gin.H{"message":"Email change cancelled and signed out everywhere, change your password if you didn't ask for it"})
+	}
+}
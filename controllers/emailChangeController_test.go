@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rest-api/components"
+	"rest-api/models"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func requestEmailChange(mt *mtest.T, userId, sessionId string, body map[string]string) *httptest.ResponseRecorder{
+	router := gin.New()
+	router.POST("/changeEmail", func(c *gin.Context){
+		c.Set(components.USERIDKEY, userId)
+		c.Set(components.SESSIONIDKEY, sessionId)
+	}, RequestEmailChangeHandler(mt.DB.Collection("Users"), mt.DB.Collection("Sessions"), mt.DB.Collection("OneTimeTokens"), mt.DB.Collection("LoginAttempts"), mt.DB.Collection("AuditEvents"), &components.OutboxMailer{Dir: mt.TempDir(), From: "no-reply@localhost"}))
+	encoded, _ := json.Marshal(body)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/changeEmail", bytes.NewReader(encoded)))
+	return recorder
+}
+
+func TestRequestEmailChangeProof(t *testing.T){
+	gin.SetMode(gin.TestMode)
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	id := primitive.NewObjectID()
+	sessionId := primitive.NewObjectID().Hex()
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil{
+		t.Fatal(err)
+	}
+	user := bson.D{
+		{Key: "_id", Value: id},
+		{Key: "username", Value: "user"},
+		{Key: "email", Value: "user@example.com"},
+		{Key: "password", Value: string(hashedPassword)},
+	}
+
+	mt.Run("wrong password counts as failure", func(mt *mtest.T){
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch, user),
+			mtest.CreateCursorResponse(0, "db.LoginAttempts", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: attemptDocument(emailAttemptKey("user@example.com"), 1)}),
+			mtest.CreateSuccessResponse(),
+		)
+		recorder := requestEmailChange(mt, id.Hex(), sessionId, map[string]string{"newEmail": "new@example.com", "password": "wrong"})
+		if recorder.Code != http.StatusUnauthorized{
+			mt.Fatalf("expected status 401, got %d", recorder.Code)
+		}
+		events := mt.GetAllStartedEvents()
+		if events[2].CommandName != "findAndModify" || events[2].Command.Lookup("query", "key").StringValue() != emailAttemptKey("user@example.com"){
+			mt.Fatalf("expected failure of the account to be recorded, got %s %s", events[2].CommandName, events[2].Command)
+		}
+		audited := events[3].Command.Lookup("documents").Array().Index(0).Value().Document()
+		if audited.Lookup("type").StringValue() != string(models.EVENTEMAILCHANGE) || audited.Lookup("outcome").StringValue() != string(models.OUTCOMEFAILURE){
+			mt.Fatalf("expected failed email change to be audited, got %s", audited)
+		}
+	})
+
+	mt.Run("locked account", func(mt *mtest.T){
+		locked := append(attemptDocument(mfaAttemptKey(id.Hex()), 5), bson.E{Key: "lockedUntil", Value: time.Now().Add(time.Minute)})
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch, user),
+			mtest.CreateCursorResponse(0, "db.LoginAttempts", mtest.FirstBatch, locked),
+			mtest.CreateSuccessResponse(),
+		)
+		recorder := requestEmailChange(mt, id.Hex(), sessionId, map[string]string{"newEmail": "new@example.com", "code": "000000"})
+		if recorder.Code != http.StatusTooManyRequests{
+			mt.Fatalf("expected status 429, got %d", recorder.Code)
+		}
+		if key := mt.GetAllStartedEvents()[1].Command.Lookup("filter", "key").StringValue(); key != mfaAttemptKey(id.Hex()){
+			mt.Fatalf("expected code guesses limited per user, got %s", key)
+		}
+	})
+
+	mt.Run("revoked session", func(mt *mtest.T){
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch, user),
+			mtest.CreateCursorResponse(0, "db.LoginAttempts", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "db.Sessions", mtest.FirstBatch),
+		)
+		recorder := requestEmailChange(mt, id.Hex(), sessionId, map[string]string{"newEmail": "new@example.com"})
+		if recorder.Code != http.StatusUnauthorized{
+			mt.Fatalf("expected status 401, got %d", recorder.Code)
+		}
+		// Asking without proof isn't a guess
+		if count := len(mt.GetAllStartedEvents()); count != 3{
+			mt.Fatalf("expected no failure recorded, got %d commands", count)
+		}
+	})
+}
+
+func TestConfirmEmailChangeTaken(t *testing.T){
+	gin.SetMode(gin.TestMode)
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("email taken after the check", func(mt *mtest.T){
+		id := primitive.NewObjectID()
+		changeToken := bson.D{
+			{Key: "_id", Value: "token"},
+			{Key: "userId", Value: id.Hex()},
+			{Key: "purpose", Value: models.PURPOSEEMAILCHANGE},
+			{Key: "data", Value: bson.D{{Key: "oldEmail", Value: "user@example.com"}, {Key: "newEmail", Value: "new@example.com"}}},
+		}
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: changeToken}),
+			mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch, bson.D{{Key: "_id", Value: id}, {Key: "email", Value: "user@example.com"}}),
+			mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key error"}),
+		)
+		handler := ConfirmEmailChangeHandler(mt.DB.Collection("Users"), mt.DB.Collection("Sessions"), mt.DB.Collection("RefreshTokens"), mt.DB.Collection("ApiKeys"), mt.DB.Collection("OneTimeTokens"), mt.DB.Collection("AuditEvents"), &components.OutboxMailer{Dir: mt.TempDir(), From: "no-reply@localhost"})
+		router := gin.New()
+		router.POST("/confirmEmailChange", handler)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/confirmEmailChange", bytes.NewReader([]byte(`{"token":"token"}`))))
+		if recorder.Code != http.StatusConflict{
+			mt.Fatalf("expected status 409, got %d %s", recorder.Code, recorder.Body.String())
+		}
+	})
+}
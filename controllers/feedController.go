@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"rest-api/components"
+	"rest-api/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Optional Query (cursor, limit)
+Returns a page of the feed of requesting user along with cursor of the next page
+*/
+func GetFeedHandler(feed components.Feed) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving page size from query
+		limit := DEFAULTPAGESIZE
+		if value := c.Query("limit"); value != ""{
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || parsed < 1 || parsed > MAXPAGESIZE{
+				c.JSON(http.StatusBadRequest, gin.H{"message":"'limit' should be between 1 and " + strconv.FormatInt(MAXPAGESIZE, 10)})
+				c.Abort()
+				return
+			}
+			limit = parsed
+		}
+
+		page, err := feed.GetFeed(c.GetString(components.USERIDKEY), c.Query("cursor"), limit)
+		if errors.Is(err, components.ErrInvalidFeedCursor){
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		for i := range page.Lessons{
+			hideAnonymousAuthor(c, &page.Lessons[i])
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+/*
+Requires Query (id: categoryId)
+Lessons of the category start showing up in the feed of requesting user
+*/
+func SubscribeCategoryHandler(categoryColl, subscriptionColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving category from query
+		categoryId := c.Query("id")
+		if categoryId == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"unable to find 'id' in query"})
+			c.Abort()
+			return
+		}
+		if _, err := models.GetCategory(categoryId, categoryColl); err != nil{
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such category exists"})
+			c.Abort()
+			return
+		}
+
+		if err := models.AddCategorySubscription(c.GetString(components.USERIDKEY), categoryId, subscriptionColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully subscribed to category", "isSubscribed": true})
+	}
+}
+
+/*
+Requires Query (id: categoryId)
+*/
+func UnsubscribeCategoryHandler(subscriptionColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving category from query
+		categoryId := c.Query("id")
+		if categoryId == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"unable to find 'id' in query"})
+			c.Abort()
+			return
+		}
+
+		if _, err := models.RemoveCategorySubscription(c.GetString(components.USERIDKEY), categoryId, subscriptionColl); err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully unsubscribed from category", "isSubscribed": false})
+	}
+}
+
+// Returns categories the requesting user is subscribed to
+func GetCategorySubscriptionsHandler(subscriptionColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		subscriptions, err := models.GetCategorySubscriptions(c.GetString(components.USERIDKEY), subscriptionColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusOK, subscriptions)
+	}
+}
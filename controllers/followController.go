@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"net/http"
+	"rest-api/components"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Requires Query (id: userId)
+Requesting user starts following the user
+Not allowed when either of them has blocked the other
+*/
+func FollowUserHandler(userColl, followColl, relationColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving user to follow from query
+		followeeId := c.Query("id")
+		if followeeId == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"unable to find 'id' in query"})
+			c.Abort()
+			return
+		}
+		followerId := c.GetString(components.USERIDKEY)
+		if followerId == followeeId{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"cannot follow yourself"})
+			c.Abort()
+			return
+		}
+		followee, err := models.GetUserById(followeeId, userColl)
+		if err != nil || followee.IsHidden(){
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+		blocked, err := models.IsBlockedBetween(followerId, followee.ID, relationColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if blocked{
+			c.JSON(http.StatusForbidden, gin.H{"message":"cannot follow this user"})
+			c.Abort()
+			return
+		}
+
+		if err := models.AddFollow(followerId, followee.ID, followColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully followed user", "isFollowing": true})
+	}
+}
+
+/*
+Requires Query (id: userId)
+*/
+func UnfollowUserHandler(followColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving user to unfollow from query
+		followeeId := c.Query("id")
+		if followeeId == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"unable to find 'id' in query"})
+			c.Abort()
+			return
+		}
+
+		if _, err := models.RemoveFollow(c.GetString(components.USERIDKEY), followeeId, followColl); err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully unfollowed user", "isFollowing": false})
+	}
+}
+
+/*
+Requires Query (id: userId)
+Optional Query (page, pageSize)
+Returns users following the user
+*/
+func GetFollowersHandler(userColl, followColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		respondWithFollowList(c, true, userColl, followColl)
+	}
+}
+
+/*
+Requires Query (id: userId)
+Optional Query (page, pageSize)
+Returns users the user follows
+*/
+func GetFollowingHandler(userColl, followColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		respondWithFollowList(c, false, userColl, followColl)
+	}
+}
+
+/*
+Lists other ends of the follow edges of a user
+Each entry tells whether the requesting user follows them
+*/
+func respondWithFollowList(c *gin.Context, followers bool, userColl, followColl *mongo.Collection){
+
+	// Retrieving user and page from query
+	userId := c.Query("id")
+	if userId == ""{
+		c.JSON(http.StatusBadRequest, gin.H{"message":"unable to find 'id' in query"})
+		c.Abort()
+		return
+	}
+	page, pageSize, err := parsePage(c)
+	if err != nil{
+		c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+		c.Abort()
+		return
+	}
+
+	// Hidden users have no public profile, nor lists
+	subject, err := models.GetUserById(userId, userColl)
+	if err != nil || subject.IsHidden(){
+		c.JSON(http.StatusNotFound, gin.H{"message":"no such user exists"})
+		c.Abort()
+		return
+	}
+
+	// Retrieving the edges
+	var follows []models.Follow
+	if followers{
+		follows, err = models.GetFollowers(userId, page, pageSize, followColl)
+	}else{
+		follows, err = models.GetFollowing(userId, page, pageSize, followColl)
+	}
+	if err != nil{
+		c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+		c.Abort()
+		return
+	}
+
+	// Retrieving users at the other end
+	userIds := make([]string, 0, len(follows))
+	for _, follow := range follows{
+		other := follow.FolloweeId
+		if followers{
+			other = follow.FollowerId
+		}
+		userIds = append(userIds, other.Hex())
+	}
+	users, err := models.GetUsersById(userIds, userColl)
+	if err != nil{
+		c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+		c.Abort()
+		return
+	}
+	usersById := make(map[string]models.User, len(users))
+	for _, user := range users{
+		usersById[user.ID] = user
+	}
+	followed, err := models.GetFollowedAmong(c.GetString(components.USERIDKEY), userIds, followColl)
+	if err != nil{
+		c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+		c.Abort()
+		return
+	}
+
+	// Deleted and hidden users are left out
+	items := make([]models.FollowListItem, 0, len(follows))
+	for i, follow := range follows{
+		user, ok := usersById[userIds[i]]
+		if !ok || user.IsHidden(){
+			continue
+		}
+		items = append(items, models.FollowListItem{
+			User: user.ToUserSummary(),
+			FollowedOn: follow.FollowedOn,
+			IsFollowing: followed[user.ID],
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": items,
+		"page": page,
+		"pageSize": pageSize,
+		"hasMore": int64(len(follows)) == pageSize,
+	})
+}
+
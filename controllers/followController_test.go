@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func getFollowers(mt *mtest.T, userId string) *httptest.ResponseRecorder{
+	router := gin.New()
+	router.GET("/followers", GetFollowersHandler(mt.DB.Collection("Users"), mt.DB.Collection("Follows")))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/followers?id=" + userId, nil))
+	return recorder
+}
+
+func TestFollowList(t *testing.T){
+	gin.SetMode(gin.TestMode)
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	id := primitive.NewObjectID()
+
+	mt.Run("hidden user", func(mt *mtest.T){
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch, bson.D{{Key: "_id", Value: id}, {Key: "banned", Value: true}, {Key: "contentHidden", Value: true}}))
+		if recorder := getFollowers(mt, id.Hex()); recorder.Code != http.StatusNotFound{
+			mt.Fatalf("expected status 404, got %d", recorder.Code)
+		}
+		if count := len(mt.GetAllStartedEvents()); count != 1{
+			mt.Fatalf("expected follows not to be read, got %d commands", count)
+		}
+	})
+
+	mt.Run("users at the other end unavailable", func(mt *mtest.T){
+		follow := bson.D{
+			{Key: "_id", Value: "follow"},
+			{Key: "followerId", Value: primitive.NewObjectID()},
+			{Key: "followeeId", Value: id},
+			{Key: "followedOn", Value: time.Now()},
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch, bson.D{{Key: "_id", Value: id}}),
+			mtest.CreateCursorResponse(0, "db.Follows", mtest.FirstBatch, follow),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 6, Name: "HostUnreachable", Message: "unreachable"}),
+		)
+		// Failure isn't passed off as an empty list
+		if recorder := getFollowers(mt, id.Hex()); recorder.Code != http.StatusInternalServerError{
+			mt.Fatalf("expected status 500, got %d %s", recorder.Code, recorder.Body.String())
+		}
+	})
+}
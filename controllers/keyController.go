@@ -0,0 +1,18 @@
+package controllers
+
+import (
+	"net/http"
+	"rest-api/components"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Publishes public keys used for verifying access tokens
+*/
+func GetJWKSHandler() gin.HandlerFunc{
+	return func(c *gin.Context){
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, components.GetJWKS())
+	}
+}
@@ -2,13 +2,15 @@ package controllers
 
 import (
 	"context"
-	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 	"rest-api/components"
 	"rest-api/models"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -17,91 +19,73 @@ import (
 
 // Everytime user opens the app
 // During the splash screen, this login should take place
-func LoginUserWithTokenHandler(coll *mongo.Collection)gin.HandlerFunc{
+// Exchanges refresh token for new access token and rotates the refresh token
+func LoginUserWithTokenHandler(userColl, sessionColl, refreshColl, auditColl *mongo.Collection)gin.HandlerFunc{
 	return func(c *gin.Context){
 
-		// Retrieving token from request
-		tokenString, err := components.GetBearerToken(c)
-		if err != nil{
-			c.JSON(404, gin.H{"message":err.Error()})
+		// Retrieving refresh token from request body
+		var body struct{
+			RefreshToken string `json:"refreshToken"`
+		}
+		if err := c.BindJSON(&body); err != nil || body.RefreshToken == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"refresh token not provided"})
 			c.Abort()
-			return 
+			return
 		}
 
-		// Retrieving Secret for verifying JWT token retrieved from request
-		secret, err := components.GetJWTSecret()
+		// Consuming the refresh token
+		// Reused token revokes every token of its family
+		refreshToken, err := models.UseRefreshToken(components.HashToken(body.RefreshToken), refreshColl)
+		if err == models.ErrRefreshTokenReused{
+			// Session of the reused token can't be trusted anymore
+			models.RevokeSession(refreshToken.FamilyId, refreshToken.UserId, sessionColl)
+			components.RecordAuditEvent(c, models.EVENTTOKENREFRESH, models.OUTCOMEFAILURE, refreshToken.UserId, map[string]string{"sessionId": refreshToken.FamilyId, "reason": "refresh token reused"}, auditColl)
+		}
 		if err != nil{
-			panic("Unable to find jwt secret")
+			c.JSON(http.StatusUnauthorized, gin.H{"message":err.Error()})
+			c.Abort()
+			return
 		}
-		secretKey := []byte(secret)
-
-		// Parsing JWT token from string and Verifying the signature
-		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
 
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return "", errors.New("unable to parse token")
-			}
-			return secretKey, nil
-		})
+		// Verifying that the session of the refresh token is still active
+		session, err := models.GetActiveSession(refreshToken.FamilyId, refreshToken.UserId, sessionColl)
 		if err != nil{
 			c.JSON(http.StatusUnauthorized, gin.H{"message":err.Error()})
 			c.Abort()
 			return
 		}
+		models.TouchSession(session, c.ClientIP(), sessionColl)
 
-		// Checking if Parsed JWT token is valid
-		// token.Valid && // to omit whether the token has expired or not
-		// because it may have been expired
-		if claims, ok := token.Claims.(jwt.MapClaims); token.Valid && ok{
-			email, password, admin:= claims["email"], claims["password"], claims["admin"].(bool)
-
-			// Finding user with credentials in JWT token
-			var user models.User
-			filter := bson.M{"email": email}			
-			result := coll.FindOne(context.TODO(), filter)
-			if err := result.Decode(&user); err != nil{
-				c.JSON(404, gin.H{"message":"Cannot find user"})
-				c.Abort()
-				return
-			}
+		// Finding user the refresh token belongs to
+		user, err := models.GetUserById(refreshToken.UserId, userColl)
+		if err != nil{
+			c.JSON(http.StatusUnauthorized, gin.H{"message":"Cannot find user"})
+			c.Abort()
+			return
+		}
 
-			// Check whether this was the token last assigned
-			if tokenString != user.LastToken{
-				c.JSON(http.StatusUnauthorized, gin.H{"message":"not the latest token assigned"})
-				c.Abort()
-				return
-			}
+		// Suspended and banned users can't get new tokens
+		if rejectIfRestricted(c, user){
+			components.RecordAuditEvent(c, models.EVENTTOKENREFRESH, models.OUTCOMEFAILURE, user.ID, map[string]string{"sessionId": session.ID, "reason": "account restricted"}, auditColl)
+			return
+		}
 
-			if user.Email == email && user.Password == password{
-
-				// Generating and sending new JWT token
-				tokenString,err := components.GenerateJWTToken(admin, user.Email, user.Password)
-				if err != nil{
-					c.JSON(404, gin.H{"message":err.Error()})
-					c.Abort()
-					return
-				}
-
-				// Updating user's last token
-				err = models.UpdateLastToken(user.Email, tokenString, coll)
-				if err!= nil{
-					c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})	
-					c.Abort()
-					return
-				}
-
-				// Returning new token to user
-				c.JSON(http.StatusOK, gin.H{"token":tokenString, "userId": user.ID})
-				return
-			}
+		// Generating new tokens in the same session
+		tokens, err := components.IssueTokens(c, user, session.ID, sessionColl, refreshColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
 		}
-		c.JSON(http.StatusUnauthorized, gin.H{"message":"Invalid token"})
+		components.RecordAuditEvent(c, models.EVENTTOKENREFRESH, models.OUTCOMESUCCESS, user.ID, map[string]string{"sessionId": session.ID}, auditColl)
+
+		c.JSON(http.StatusOK, tokens)
 	}
 }
 
 
 /* Initial sign up for new users */
-func SignUpUserHandler(coll *mongo.Collection) gin.HandlerFunc{
+func SignUpUserHandler(userColl, sessionColl, refreshColl, tokenColl, auditColl *mongo.Collection, mailer components.Mailer) gin.HandlerFunc{
 	return func(c *gin.Context){
 
 		// Retrieving userrequest body from request body
@@ -111,57 +95,72 @@ func SignUpUserHandler(coll *mongo.Collection) gin.HandlerFunc{
 			c.Abort()
 			return
 		}
+		user.Email = models.NormalizeEmail(user.Email)
+		if err := components.ValidateEmail(user.Email); err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
 		
+		// Checking password against the policy
+		if err := components.GetPasswordPolicy().Validate(user.Password, user.Email, user.Username); err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
 		// Generating hash of user password and replacing with user requested password 
-		hashedPassword,err := bcrypt.GenerateFromPassword([]byte(user.Password), models.COST)
+		hashedPassword,err := components.HashPassword(user.Password)
 		if err != nil{
 			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
 			c.Abort()
 			return
 		}
-		user.Password = string(hashedPassword)
+		user.Password = hashedPassword
 
 
-		// Admin accounts to check for admins
-		admins := []string{"codingsubs73@gmail.com"}
-
-		// Checking if email provided is admin
-		isAdmin := false
-		for _, admin:= range admins{
-			if admin == user.Email{
-				isAdmin = true
-				break
-			} 
-		}
-
-		// Generating token with user credentials
-		token, err := components.GenerateJWTToken(isAdmin, user.Email, user.Password)
+		// Adding user to db
+		// Done after all conversions to make user user is ready to be added to db
+		// Every user starts as normal user, admins promote them afterwards
+		result, err := user.ToUserIntermediate(models.ROLEUSER).AddUser(userColl)
 		if err != nil{
 			c.JSON(404, gin.H{"message":err.Error()})
 			c.Abort()
 			return
 		}
 
-		// Adding user to db and adding latest token
-		// Done after all conversions to make user user is ready to be added to db
-		result, err := user.ToUserIntermediate(isAdmin, token).AddUser(coll)
+		// Generating tokens for the newly added user
+		newUser, err := models.GetUserById(result.InsertedID.(primitive.ObjectID).Hex(), userColl)
 		if err != nil{
-			c.JSON(404, gin.H{"message":err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		components.RecordAuditEvent(c, models.EVENTSIGNUP, models.OUTCOMESUCCESS, newUser.ID, map[string]string{"method": "password"}, auditColl)
+		tokens, err := components.IssueTokens(c, newUser, "", sessionColl, refreshColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
 			c.Abort()
 			return
 		}
 
-		// Returning the token
-		c.JSON(http.StatusOK, gin.H{"token":token, "userId":result.InsertedID.(primitive.ObjectID).Hex()})
+		// Account stays limited until email is verified
+		// Failing to send is not fatal, user can ask for another email
+		if err := sendVerificationEmail(newUser, tokenColl, mailer); err != nil{
+			log.Println("Unable to send verification email to", newUser.Email, ":", err.Error())
+		}
+
+		// Returning the tokens
+		c.JSON(http.StatusOK, tokens)
 	}
 }
 
 /*
 note:
-	1. JWT token password is hashed password
+	1. JWT token only identifies the user by id, password hash never leaves the server
 	2. Hashing only takes place in Signing Up and Logging in using password
 */
-func LoginUserWithPasswordHandler(coll *mongo.Collection)gin.HandlerFunc{
+func LoginUserWithPasswordHandler(userColl, sessionColl, refreshColl, attemptColl, auditColl *mongo.Collection)gin.HandlerFunc{
 	return func(c *gin.Context){
 		var credentials struct{
 			Email string `json:"email"`
@@ -174,41 +173,164 @@ func LoginUserWithPasswordHandler(coll *mongo.Collection)gin.HandlerFunc{
 			c.Abort()
 			return
 		}
+		credentials.Email = models.NormalizeEmail(credentials.Email)
+
+		// Rejecting locked accounts and ips before checking the password
+		emailKey, ipKey := emailAttemptKey(credentials.Email), ipAttemptKey(c.ClientIP())
+		if rejectIfLocked(c, attemptColl, emailKey, ipKey){
+			components.RecordAuditEvent(c, models.EVENTLOGIN, models.OUTCOMEFAILURE, "", map[string]string{"method": "password", "email": credentials.Email, "reason": "locked"}, auditColl)
+			return
+		}
 
 		// Finding user with provided credentials
+		// Same response and similar timing whether the user exists or not
 		filter := bson.M{"email":credentials.Email}
-		result := coll.FindOne(context.TODO(), filter)
+		result := userColl.FindOne(context.TODO(), filter)
 		var user models.User
-		err := result.Decode(&user)
-		if err != nil{
-			c.JSON(http.StatusBadRequest, gin.H{"message":"No such user found with given email"})
+		if err := result.Decode(&user); err != nil{
+			bcrypt.CompareHashAndPassword(getDummyPasswordHash(), []byte(credentials.Password))
+			recordLoginFailure(attemptColl, emailKey, ipKey)
+			components.RecordAuditEvent(c, models.EVENTLOGIN, models.OUTCOMEFAILURE, "", map[string]string{"method": "password", "email": credentials.Email, "reason": "unknown email"}, auditColl)
+			c.JSON(http.StatusBadRequest, gin.H{"message":"Wrong email or password"})
 			c.Abort()
 			return
 		}
 
 		// Compare hash password with plain password
 		if user.Email != credentials.Email || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(credentials.Password)) != nil{
+			recordLoginFailure(attemptColl, emailKey, ipKey)
+			components.RecordAuditEvent(c, models.EVENTLOGIN, models.OUTCOMEFAILURE, user.ID, map[string]string{"method": "password", "reason": "wrong password"}, auditColl)
 			c.JSON(http.StatusBadRequest, gin.H{"message":"Wrong email or password"})
 			c.Abort()
 			return
 		}
+		models.ClearLoginAttempts(emailKey, attemptColl)
+
+		// Upgrading hashes made with lower cost, plain password is only available now
+		if components.NeedsRehash(user.Password){
+			if hashedPassword, err := components.HashPassword(credentials.Password); err == nil{
+				models.RehashPassword(user.ID, user.Password, hashedPassword, userColl)
+			}
+		}
+
+		// Generating new tokens or asking for second factor
+		signInUser(c, &user, "password", sessionColl, refreshColl, auditColl)
+	}
+}
+
+// Compared against when no user exists so that timing doesn't reveal existence of accounts
+// Generated lazily so that it uses the configured cost
+var dummyPasswordHash []byte
+var dummyPasswordHashOnce sync.Once
+
+func getDummyPasswordHash() []byte{
+	dummyPasswordHashOnce.Do(func(){
+		hashedPassword, _ := components.HashPassword("dummy password")
+		dummyPasswordHash = []byte(hashedPassword)
+	})
+	return dummyPasswordHash
+}
+
+// Keys failed sign in attempts are tracked by
+func emailAttemptKey(email string) string{
+	return "email:" + models.NormalizeEmail(email)
+}
+
+func ipAttemptKey(ip string) string{
+	return "ip:" + ip
+}
+
+func mfaAttemptKey(userId string) string{
+	return "mfa:" + userId
+}
+
+// Clears password and second factor attempts of the user
+func clearAccountLockout(user *models.User, attemptColl *mongo.Collection) error{
+	for _, key := range []string{emailAttemptKey(user.Email), mfaAttemptKey(user.ID)}{
+		if err := models.ClearLoginAttempts(key, attemptColl); err != nil{
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Responds with 429 when any of the keys is locked
+Returns whether the request was rejected
+*/
+func rejectIfLocked(c *gin.Context, attemptColl *mongo.Collection, keys ...string) bool{
+	for _, key := range keys{
+		lockedUntil, err := models.GetLockedUntil(key, attemptColl)
 		if err != nil{
 			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
 			c.Abort()
-			return
+			return true
+		}
+		if !lockedUntil.IsZero(){
+			retryAfter := int(time.Until(lockedUntil).Seconds()) + 1
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{"message":"Too many failed attempts, try again later", "retryAfter": retryAfter})
+			c.Abort()
+			return true
 		}
+	}
+	return false
+}
 
-		// Generating new token
-		token, err := components.GenerateJWTToken(user.IsAdmin, user.Email, user.Password)
+/*
+Responds with 403 when the user is suspended or banned
+Returns whether the request was rejected
+*/
+func rejectIfRestricted(c *gin.Context, user *models.User) bool{
+	if err := user.CheckStanding(time.Now()); err != nil{
+		c.JSON(http.StatusForbidden, components.AccountRestrictedResponse(err.(*models.AccountRestrictedError)))
+		c.Abort()
+		return true
+	}
+	return false
+}
+
+func recordLoginFailure(attemptColl *mongo.Collection, emailKey, ipKey string){
+	if err := models.RecordLoginFailure(emailKey, models.ACCOUNTLOCKOUT, attemptColl); err != nil{
+		log.Println("Unable to record failed sign in:", err.Error())
+	}
+	if err := models.RecordLoginFailure(ipKey, models.IPLOCKOUT, attemptColl); err != nil{
+		log.Println("Unable to record failed sign in:", err.Error())
+	}
+}
+
+/*
+Final step of signing in once the user has proven their identity with @method
+Users with 2FA get a short lived challenge token instead,
+which is exchanged for tokens along with a valid code
+*/
+func signInUser(c *gin.Context, user *models.User, method string, sessionColl, refreshColl, auditColl *mongo.Collection){
+	if rejectIfRestricted(c, user){
+		components.RecordAuditEvent(c, models.EVENTLOGIN, models.OUTCOMEFAILURE, user.ID, map[string]string{"method": method, "reason": "account restricted"}, auditColl)
+		return
+	}
+
+	if user.TOTPEnabled{
+		challengeToken, err := components.GenerateChallengeToken(user.ID, MFACHALLENGEPURPOSE, MFACHALLENGEEXPIRY)
 		if err != nil{
-			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
 			c.Abort()
 			return
 		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfaRequired": true,
+			"challengeToken": challengeToken,
+			"expiresIn": int64(MFACHALLENGEEXPIRY.Seconds()),
+		})
+		return
+	}
 
-		// Updating previous token
-		models.UpdateLastToken(user.Email, token, coll)
-
-		c.JSON(http.StatusOK, gin.H{"token":token, "userId":user.ID})
+	tokens, err := components.IssueTokens(c, user, "", sessionColl, refreshColl)
+	if err != nil{
+		c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+		c.Abort()
+		return
 	}
-}
\ No newline at end of file
+	components.RecordAuditEvent(c, models.EVENTLOGIN, models.OUTCOMESUCCESS, user.ID, map[string]string{"method": method}, auditColl)
+	c.JSON(http.StatusOK, tokens)
+}
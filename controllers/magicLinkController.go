@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"rest-api/components"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const MAGICLINKEXPIRY = time.Minute*15
+
+// Links sent are counted apart from failed sign ins
+func magicLinkEmailKey(email string) string{
+	return "magicLink:" + emailAttemptKey(email)
+}
+
+func magicLinkIPKey(ip string) string{
+	return "magicLink:" + ipAttemptKey(ip)
+}
+
+/*
+Sends single use sign in link to the email, account is created on first sign in
+Returned device token has to be presented along with the link,
+so that a link intercepted on the way can't be used from another device
+Response is the same whether the account exists or not
+Links sent are limited per email and per ip
+*/
+func RequestMagicLinkHandler(userColl, tokenColl, attemptColl *mongo.Collection, mailer components.Mailer) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving email from request body
+		var body struct{
+			Email string `json:"email"`
+		}
+		if err := c.BindJSON(&body); err != nil || body.Email == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"email not provided"})
+			c.Abort()
+			return
+		}
+		body.Email = models.NormalizeEmail(body.Email)
+		if err := components.ValidateEmail(body.Email); err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Limiting links sent, whether the account exists or not
+		emailKey, ipKey := magicLinkEmailKey(body.Email), magicLinkIPKey(c.ClientIP())
+		if rejectIfLocked(c, attemptColl, emailKey, ipKey){
+			return
+		}
+		if err := models.RecordLoginFailure(emailKey, models.MAGICLINKEMAILLIMIT, attemptColl); err != nil{
+			log.Println("Unable to record sign in link request:", err.Error())
+		}
+		if err := models.RecordLoginFailure(ipKey, models.MAGICLINKIPLIMIT, attemptColl); err != nil{
+			log.Println("Unable to record sign in link request:", err.Error())
+		}
+
+		// Secret kept by the requesting device, only its hash is bound to the link
+		deviceToken, err := components.GenerateOpaqueToken()
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Only the latest link sent to the email works
+		userId, username := "", strings.Split(body.Email, "@")[0]
+		if user, err := models.GetUserByEmail(body.Email, userColl); err == nil{
+			userId, username = user.ID, user.Username
+		}
+		if err := models.InvalidateMagicLinks(body.Email, tokenColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		data := map[string]string{"email": body.Email, "deviceHash": components.HashToken(deviceToken)}
+		token, err := components.IssueOneTimeToken(userId, models.PURPOSEMAGICLINK, MAGICLINKEXPIRY, data, tokenColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Sending the link, failing to send is not revealed
+		link := components.GetAppURL() + "/magicLink?token=" + url.QueryEscape(token)
+		err = mailer.Send(components.Mail{
+			To: body.Email,
+			Subject: "Your sign in link",
+			Body: "Hi " + username + ",\n\n" +
+				"Open the following link on the device you requested it from to sign in, it is valid for 15 minutes:\n" +
+				link + "\n\n" +
+				"If you didn't ask for this, you can ignore this email.\n",
+		})
+		if err != nil{
+			log.Println("Unable to send sign in link to", body.Email, ":", err.Error())
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "If the email is valid, a sign in link has been sent",
+			"deviceToken": deviceToken,
+			"expiresIn": int64(MAGICLINKEXPIRY.Seconds()),
+		})
+	}
+}
+
+/*
+Exchanges token from the sign in link along with the device token for new tokens
+Response is the same as signing in with password
+*/
+func LoginUserWithMagicLinkHandler(userColl, sessionColl, refreshColl, tokenColl, auditColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving link token and device token from request body
+		var body struct{
+			Token string `json:"token"`
+			DeviceToken string `json:"deviceToken"`
+		}
+		if err := c.BindJSON(&body); err != nil || body.Token == "" || body.DeviceToken == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"token and deviceToken are required"})
+			c.Abort()
+			return
+		}
+
+		// Checking the device before the link is consumed,
+		// so that an intercepted link can't be burnt from another device
+		tokenHash := components.HashToken(body.Token)
+		pendingToken, err := models.GetOneTimeToken(tokenHash, models.PURPOSEMAGICLINK, tokenColl)
+		if err != nil{
+			c.JSON(http.StatusUnauthorized, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		deviceHash := components.HashToken(body.DeviceToken)
+		if subtle.ConstantTimeCompare([]byte(deviceHash), []byte(pendingToken.Data["deviceHash"])) != 1{
+			components.RecordAuditEvent(c, models.EVENTLOGIN, models.OUTCOMEFAILURE, pendingToken.UserId, map[string]string{"method": "magicLink", "email": pendingToken.Data["email"], "reason": "device mismatch"}, auditColl)
+			c.JSON(http.StatusUnauthorized, gin.H{"message":"link was requested from another device"})
+			c.Abort()
+			return
+		}
+
+		// Consuming the link token
+		linkToken, err := models.UseOneTimeToken(tokenHash, models.PURPOSEMAGICLINK, tokenColl)
+		if err != nil{
+			c.JSON(http.StatusUnauthorized, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		email := linkToken.Data["email"]
+
+		// Finding the user or creating one on first sign in
+		user, err := models.GetUserByEmail(email, userColl)
+		if err == mongo.ErrNoDocuments{
+			user, err = createUserWithoutPassword(strings.Split(email, "@")[0], email, "", userColl)
+			if err == nil{
+				components.RecordAuditEvent(c, models.EVENTSIGNUP, models.OUTCOMESUCCESS, user.ID, map[string]string{"method": "magicLink"}, auditColl)
+			}
+		}
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Account must still be the one the link was sent for
+		if linkToken.UserId != "" && linkToken.UserId != user.ID{
+			c.JSON(http.StatusUnauthorized, gin.H{"message":"invalid or expired token"})
+			c.Abort()
+			return
+		}
+
+		// Unverified account could have been registered by someone else with this email
+		if !user.Verified{
+			c.JSON(http.StatusForbidden, gin.H{"message":"account with this email is not verified, verify it or sign in with password first"})
+			c.Abort()
+			return
+		}
+
+		// Generating new tokens or asking for second factor
+		signInUser(c, user, "magicLink", sessionColl, refreshColl, auditColl)
+	}
+}
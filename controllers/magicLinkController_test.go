@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"rest-api/components"
+	"rest-api/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Emails written by the outbox mailer, oldest first
+func readOutbox(t *testing.T, dir string) []string{
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil{
+		t.Fatal(err)
+	}
+	mails := make([]string, 0, len(files))
+	for _, file := range files{
+		content, err := os.ReadFile(file)
+		if err != nil{
+			t.Fatal(err)
+		}
+		mails = append(mails, string(content))
+	}
+	return mails
+}
+
+func requestMagicLink(handler gin.HandlerFunc, email string) *httptest.ResponseRecorder{
+	router := gin.New()
+	router.POST("/magicLink", handler)
+	body, _ := json.Marshal(map[string]string{"email": email})
+	request := httptest.NewRequest(http.MethodPost, "/magicLink", bytes.NewReader(body))
+	request.RemoteAddr = "192.0.2.1:1234"
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func attemptDocument(key string, failures int) bson.D{
+	return bson.D{{Key: "key", Value: key}, {Key: "failures", Value: failures}, {Key: "lastFailureOn", Value: time.Now()}}
+}
+
+func TestRequestMagicLink(t *testing.T){
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_URL", "http://app.example")
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	email := "new@example.com"
+	linkPattern := regexp.MustCompile(`http://app\.example/magicLink\?token=(\S+)`)
+
+	mt.Run("link for unknown email", func(mt *mtest.T){
+		mailer := &components.OutboxMailer{Dir: t.TempDir(), From: "no-reply@localhost"}
+		tokenColl := mt.DB.Collection("OneTimeTokens")
+		mt.AddMockResponses(
+			// Neither the email nor the ip is locked
+			mtest.CreateCursorResponse(0, "db.LoginAttempts", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "db.LoginAttempts", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: attemptDocument(magicLinkEmailKey(email), 1)}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: attemptDocument(magicLinkIPKey("192.0.2.1"), 1)}),
+			// No account with the email
+			mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(),
+		)
+
+		recorder := requestMagicLink(RequestMagicLinkHandler(mt.DB.Collection("Users"), tokenColl, mt.DB.Collection("LoginAttempts"), mailer), email)
+		if recorder.Code != http.StatusOK{
+			mt.Fatalf("expected status 200, got %d %s", recorder.Code, recorder.Body.String())
+		}
+
+		// Earlier links to the email stop working although there is no user to match them on
+		events := mt.GetAllStartedEvents()
+		invalidation := events[5]
+		if invalidation.CommandName != "update"{
+			mt.Fatalf("expected earlier links to be invalidated, got %s", invalidation.CommandName)
+		}
+		filter := invalidation.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q").Document()
+		if filter.Lookup("data.email").StringValue() != email || filter.Lookup("purpose").StringValue() != string(models.PURPOSEMAGICLINK){
+			mt.Fatalf("expected links matched on email, got %v", filter)
+		}
+		if _, err := filter.LookupErr("userId"); err == nil{
+			mt.Fatalf("expected links not matched on user, got %v", filter)
+		}
+
+		// Link in the outbox is the one saved, bound to the device that asked for it
+		mails := readOutbox(t, mailer.Dir)
+		if len(mails) != 1 || !strings.Contains(mails[0], "To: " + email){
+			mt.Fatalf("expected a single mail to %s, got %v", email, mails)
+		}
+		match := linkPattern.FindStringSubmatch(mails[0])
+		if match == nil{
+			mt.Fatalf("expected sign in link in %s", mails[0])
+		}
+		token, err := url.QueryUnescape(match[1])
+		if err != nil{
+			mt.Fatal(err)
+		}
+		inserted := events[6].Command.Lookup("documents").Array().Index(0).Value().Document()
+		if inserted.Lookup("tokenHash").StringValue() != components.HashToken(token){
+			mt.Fatal("expected hash of the mailed token to be saved")
+		}
+		var response struct{
+			DeviceToken string `json:"deviceToken"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil{
+			mt.Fatal(err)
+		}
+		if inserted.Lookup("data", "deviceHash").StringValue() != components.HashToken(response.DeviceToken){
+			mt.Fatal("expected link to be bound to the returned device token")
+		}
+	})
+
+	mt.Run("email over the limit", func(mt *mtest.T){
+		mailer := &components.OutboxMailer{Dir: t.TempDir(), From: "no-reply@localhost"}
+		locked := append(attemptDocument(magicLinkEmailKey(email), 3), bson.E{Key: "lockedUntil", Value: time.Now().Add(time.Minute)})
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.LoginAttempts", mtest.FirstBatch, locked))
+
+		recorder := requestMagicLink(RequestMagicLinkHandler(mt.DB.Collection("Users"), mt.DB.Collection("OneTimeTokens"), mt.DB.Collection("LoginAttempts"), mailer), email)
+		if recorder.Code != http.StatusTooManyRequests{
+			mt.Fatalf("expected status 429, got %d", recorder.Code)
+		}
+		if mails := readOutbox(t, mailer.Dir); len(mails) != 0{
+			mt.Fatalf("expected no mail, got %d", len(mails))
+		}
+	})
+}
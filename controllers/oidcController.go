@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"rest-api/components"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const OIDCSTATEEXPIRY = time.Minute*10
+
+// Cookie binding the state to the browser that started the flow, so nobody can finish it in someone else's
+const OIDCSTATECOOKIE = "oidc_state"
+
+func setOIDCStateCookie(c *gin.Context, state string, maxAge int){
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(OIDCSTATECOOKIE, state, maxAge, "/", "", strings.HasPrefix(components.GetPublicURL(), "https://"), true)
+}
+
+/*
+Returns names of providers users can sign in with
+*/
+func GetOIDCProvidersHandler(providers map[string]*components.OIDCProvider) gin.HandlerFunc{
+	return func(c *gin.Context){
+		names := make([]string, 0, len(providers))
+		for name := range providers{
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c.JSON(http.StatusOK, names)
+	}
+}
+
+/*
+Requires Param (provider: provider name)
+Redirects the user to the provider for signing in
+*/
+func OIDCLoginHandler(providers map[string]*components.OIDCProvider, tokenColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving requested provider
+		provider, ok := providers[c.Param("provider")]
+		if !ok{
+			c.JSON(http.StatusNotFound, gin.H{"message":"unknown provider"})
+			c.Abort()
+			return
+		}
+
+		// Generating PKCE verifier and nonce, both are checked on callback
+		codeVerifier, codeChallenge, err := components.GeneratePKCE()
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		nonce, err := components.GenerateOpaqueToken()
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// State is a one time token carrying what's needed to finish the flow
+		data := map[string]string{
+			"provider": provider.Name,
+			"nonce": nonce,
+			"codeVerifier": codeVerifier,
+		}
+		state, err := components.IssueOneTimeToken("", models.PURPOSEOIDCSTATE, OIDCSTATEEXPIRY, data, tokenColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		authorizationURL, err := provider.AuthorizationURL(state, nonce, codeChallenge)
+		if err != nil{
+			c.JSON(http.StatusBadGateway, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		setOIDCStateCookie(c, state, int(OIDCSTATEEXPIRY.Seconds()))
+		c.Redirect(http.StatusFound, authorizationURL)
+	}
+}
+
+/*
+Requires Param (provider: provider name) and Query (code, state)
+Provider redirects the user here after signing in
+*/
+func OIDCCallbackHandler(providers map[string]*components.OIDCProvider, userColl, sessionColl, refreshColl, tokenColl, identityColl, auditColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving requested provider
+		provider, ok := providers[c.Param("provider")]
+		if !ok{
+			c.JSON(http.StatusNotFound, gin.H{"message":"unknown provider"})
+			c.Abort()
+			return
+		}
+
+		// Provider reports failures through query
+		if providerError := c.Query("error"); providerError != ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"sign in failed at provider: " + providerError})
+			c.Abort()
+			return
+		}
+		code, state := c.Query("code"), c.Query("state")
+		if code == "" || state == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"unable to find 'code' and 'state' in query"})
+			c.Abort()
+			return
+		}
+
+		// State must come back to the browser it was issued to
+		stateCookie, err := c.Cookie(OIDCSTATECOOKIE)
+		setOIDCStateCookie(c, "", -1)
+		if err != nil || subtle.ConstantTimeCompare([]byte(stateCookie), []byte(state)) != 1{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"state wasn't issued to this browser"})
+			c.Abort()
+			return
+		}
+
+		// Consuming the state issued when the flow started
+		stateToken, err := models.UseOneTimeToken(components.HashToken(state), models.PURPOSEOIDCSTATE, tokenColl)
+		if err != nil || stateToken.Data["provider"] != provider.Name{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"invalid or expired state"})
+			c.Abort()
+			return
+		}
+
+		// Exchanging the code for verified identity
+		method := "oidc:" + provider.Name
+		identity, err := provider.Exchange(code, stateToken.Data["codeVerifier"], stateToken.Data["nonce"])
+		if err != nil{
+			components.RecordAuditEvent(c, models.EVENTLOGIN, models.OUTCOMEFAILURE, "", map[string]string{"method": method, "reason": err.Error()}, auditColl)
+			c.JSON(http.StatusUnauthorized, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Finding linked user or creating one
+		user, err := findOrCreateOIDCUser(c, provider.Name, identity, userColl, identityColl, auditColl)
+		if err != nil{
+			components.RecordAuditEvent(c, models.EVENTLOGIN, models.OUTCOMEFAILURE, "", map[string]string{"method": method, "email": identity.Email, "reason": err.Error()}, auditColl)
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		signInUser(c, user, method, sessionColl, refreshColl, auditColl)
+	}
+}
+
+/*
+Returns user linked to the external identity
+Unlinked identities are linked to the user with the same verified email,
+or a new user is created on first sign in
+*/
+func findOrCreateOIDCUser(c *gin.Context, providerName string, identity *components.OIDCIdentity, userColl, identityColl, auditColl *mongo.Collection)(*models.User, error){
+
+	// Already linked identity
+	externalIdentity, err := models.GetExternalIdentity(providerName, identity.Subject, identityColl)
+	if err == nil{
+		return models.GetUserById(externalIdentity.UserId, userColl)
+	}
+	if err != mongo.ErrNoDocuments{
+		return nil, err
+	}
+
+	// Linking by email is only safe when the provider vouches for it
+	if identity.Email == "" || !identity.EmailVerified{
+		return nil, errors.New("provider did not return a verified email")
+	}
+
+	user, err := models.GetUserByEmail(identity.Email, userColl)
+	if err == mongo.ErrNoDocuments{
+		username := identity.Name
+		if username == ""{
+			username = strings.Split(identity.Email, "@")[0]
+		}
+		user, err = createUserWithoutPassword(username, identity.Email, identity.Picture, userColl)
+		if err == nil{
+			components.RecordAuditEvent(c, models.EVENTSIGNUP, models.OUTCOMESUCCESS, user.ID, map[string]string{"method": "oidc:" + providerName}, auditColl)
+		}
+	}
+	if err != nil{
+		return nil, err
+	}
+
+	// Unverified account could have been registered by someone else with this email
+	if !user.Verified{
+		return nil, errors.New("account with this email is not verified, verify it or sign in with password first")
+	}
+
+	if _, err := models.NewExternalIdentityIntermediate(user.ID, providerName, identity.Subject, identity.Email).AddExternalIdentity(identityColl); err != nil{
+		return nil, err
+	}
+	return user, nil
+}
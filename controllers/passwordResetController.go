@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+	"rest-api/components"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const PASSWORDRESETTOKENEXPIRY = time.Hour
+
+/*
+Sends password reset link to the email
+Response is the same whether the account exists or not
+*/
+func ForgotPasswordHandler(userColl, tokenColl *mongo.Collection, mailer components.Mailer) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving email from request body
+		var body struct{
+			Email string `json:"email"`
+		}
+		if err := c.BindJSON(&body); err != nil || body.Email == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"email not provided"})
+			c.Abort()
+			return
+		}
+
+		response := gin.H{"message":"If an account exists for this email, a reset link has been sent"}
+
+		// Finding user with the email
+		user, err := models.GetUserByEmail(body.Email, userColl)
+		if err != nil{
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		// Only the latest reset link works
+		if err := models.InvalidateOneTimeTokens(user.ID, models.PURPOSEPASSWORDRESET, tokenColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		token, err := components.IssueOneTimeToken(user.ID, models.PURPOSEPASSWORDRESET, PASSWORDRESETTOKENEXPIRY, nil, tokenColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Sending the reset link
+		link := components.GetAppURL() + "/resetPassword?token=" + url.QueryEscape(token)
+		err = mailer.Send(components.Mail{
+			To: user.Email,
+			Subject: "Reset your password",
+			Body: "Hi " + user.Username + ",\n\n" +
+				"Open the following link to choose a new password, it is valid for 1 hour:\n" +
+				link + "\n\n" +
+				"If you didn't ask for this, you can ignore this email.\n",
+		})
+		if err != nil{
+			log.Println("Unable to send password reset email to", user.Email, ":", err.Error())
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+/*
+Sets new password using token from the reset link
+Signs the user out of every device and lifts the lockout, the owner has proven who they are
+*/
+func ResetPasswordHandler(userColl, sessionColl, refreshColl, apiKeyColl, tokenColl, attemptColl, auditColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving reset token and new password from request body
+		var body struct{
+			Token string `json:"token"`
+			Password string `json:"password"`
+		}
+		if err := c.BindJSON(&body); err != nil || body.Token == "" || body.Password == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"token and password are required"})
+			c.Abort()
+			return
+		}
+
+		// Checking new password against the policy before the token is consumed
+		tokenHash := components.HashToken(body.Token)
+		pendingToken, err := models.GetOneTimeToken(tokenHash, models.PURPOSEPASSWORDRESET, tokenColl)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		user, err := models.GetUserById(pendingToken.UserId, userColl)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+		if err := components.GetPasswordPolicy().Validate(body.Password, user.Email, user.Username); err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Consuming the reset token
+		resetToken, err := models.UseOneTimeToken(tokenHash, models.PURPOSEPASSWORDRESET, tokenColl)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Updating the password
+		hashedPassword, err := components.HashPassword(body.Password)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		result, err := models.UpdatePassword(resetToken.UserId, hashedPassword, userColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if result.MatchedCount == 0{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+		components.RecordAuditEvent(c, models.EVENTPASSWORDCHANGE, models.OUTCOMESUCCESS, resetToken.UserId, map[string]string{"method": "reset"}, auditColl)
+
+		// Revoking every token issued for the account
+		if err := components.RevokeAllSessions(resetToken.UserId, sessionColl, refreshColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if err := models.RevokeUserApiKeys(resetToken.UserId, apiKeyColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if err := models.InvalidateOneTimeTokens(resetToken.UserId, models.PURPOSEPASSWORDRESET, tokenColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Failed attempts of whoever guessed before don't lock the owner out anymore
+		if err := clearAccountLockout(user, attemptColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully reset password, sign in with the new password"})
+	}
+}
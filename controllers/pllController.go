@@ -54,8 +54,16 @@ func AddPllHandler(pllColl, userColl, categoryColl *mongo.Collection) gin.Handle
 			return
 		}
 
+		// Anonymous posts are shown with pseudonym of the author
+		username, err := getAuthorName(&user, pllRequest.Anonymous)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			c.Abort()
+			return
+		}
+
 		// Converting request to its intermediate and adding the intermediate to the db
-		_, err = pllRequest.ToPersonalLifeLessonRequestIntermediate(user.ID, user.Username).AddPll(pllColl)
+		_, err = pllRequest.ToPersonalLifeLessonRequestIntermediate(user.ID, username).AddPll(pllColl)
 		if err != nil{
 			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 			c.Abort()
@@ -66,13 +74,21 @@ func AddPllHandler(pllColl, userColl, categoryColl *mongo.Collection) gin.Handle
 	}
 }
 
-func GetPllsHandler(coll *mongo.Collection) gin.HandlerFunc{
+func GetPllsHandler(pllColl, userColl *mongo.Collection) gin.HandlerFunc{
 	return func(c *gin.Context){
-		plls, err := models.GetPlls(coll)
+		hiddenUserIds, err := models.GetHiddenUserIds(userColl)
 		if err != nil{
 			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 			return
 		}
+		plls, err := models.GetPlls(hiddenUserIds, pllColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		for i := range plls{
+			hideAnonymousAuthor(c, &plls[i])
+		}
 		c.JSON(http.StatusOK, plls)	
 	}
 }
@@ -94,6 +110,7 @@ func GetPllHandler(coll *mongo.Collection) gin.HandlerFunc{
 			c.JSON(http.StatusBadRequest, gin.H{"message": "Cannot find data with give id!"})
 			return
 		}
+		hideAnonymousAuthor(c, pll)
 		c.JSON(http.StatusOK, *pll)	
 	}
 }
@@ -154,8 +171,16 @@ func UpdatePllHandler(pllColl, userColl, categoryColl *mongo.Collection) gin.Han
 			return
 		}
 
+		// Anonymous posts are shown with pseudonym of the author
+		username, err := getAuthorName(&user, pll.Anonymous)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
 		// Updating the pll
-		_, err = pll.UpdatePll(user.ID, user.Username, pllColl)
+		_, err = pll.UpdatePll(user.ID, username, pllColl)
 		if err != nil{
 			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
 			c.Abort()
@@ -257,4 +282,23 @@ func DislikePllsHandler(pllColl *mongo.Collection) gin.HandlerFunc{
 		models.DislikePlls(pllIds, userId.(string), pllColl)
 		c.JSON(http.StatusOK, gin.H{"message":"Successfully disliked provided personal life lessons"})
 	}
-}
\ No newline at end of file
+}
+
+// Name shown as the author of a post
+func getAuthorName(user *models.User, anonymous bool)(string, error){
+	if anonymous{
+		return components.GetPseudonym(user.ID)
+	}
+	return user.Username, nil
+}
+
+/*
+Author of anonymous post is only revealed to the author and moderators
+*/
+func hideAnonymousAuthor(c *gin.Context, pll *models.PersonalLifeLesson){
+	identity := components.GetIdentity(c)
+	if identity != nil && (identity.UserId == pll.UserId || identity.Can(components.PERMPLLMODERATE)){
+		return
+	}
+	pll.HideAuthor()
+}
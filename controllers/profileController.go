@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"rest-api/components"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	DEFAULTPAGESIZE int64 = 20
+	MAXPAGESIZE int64 = 100
+)
+
+/*
+Optional Query (page: starting from 1, pageSize: number of items)
+*/
+func parsePage(c *gin.Context)(int64, int64, error){
+	page, pageSize := int64(1), DEFAULTPAGESIZE
+	if value := c.Query("page"); value != ""{
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1{
+			return 0, 0, errors.New("'page' should be a positive number")
+		}
+		page = parsed
+	}
+	if value := c.Query("pageSize"); value != ""{
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 || parsed > MAXPAGESIZE{
+			return 0, 0, errors.New("'pageSize' should be between 1 and " + strconv.FormatInt(MAXPAGESIZE, 10))
+		}
+		pageSize = parsed
+	}
+	return page, pageSize, nil
+}
+
+/*
+Requires Query (id: userId) or (handle: handle)
+Optional Query (page, pageSize) for the lessons
+Returns public profile of the user along with their lessons, private fields like email are never included
+Anyone can see it without signing in, isFollowing is then false
+*/
+func GetProfileHandler(userColl, pllColl, followColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		page, pageSize, err := parsePage(c)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Finding user by id or handle
+		var user *models.User
+		if userId := c.Query("id"); userId != ""{
+			user, err = models.GetUserById(userId, userColl)
+		}else if handle := c.Query("handle"); handle != ""{
+			user, err = models.GetUserByHandle(handle, userColl)
+		}else{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"provide 'id' or 'handle' in query"})
+			c.Abort()
+			return
+		}
+		if err != nil || user.IsHidden(){
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+
+		// Counting lessons, likes and follows
+		profile := user.ToProfile()
+		if err := profile.CountLessons(pllColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		profile.FollowerCount, profile.FollowingCount, err = models.CountFollows(user.ID, followColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		isFollowing, err := models.IsFollowing(c.GetString(components.USERIDKEY), user.ID, followColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Retrieving requested page of lessons
+		plls, err := models.GetUserPlls(user.ID, page, pageSize, pllColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"profile": profile,
+			"isFollowing": isFollowing,
+			"lessons": plls,
+			"page": page,
+			"pageSize": pageSize,
+			"hasMore": page*pageSize < profile.LessonCount,
+		})
+	}
+}
+
+/*
+Updates handle and bio of the requesting user
+*/
+func UpdateProfileHandler(userColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving profile from request body
+		var request models.ProfileUpdateRequest
+		if err := c.BindJSON(&request); err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if err := request.Validate(); err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Handle uniqueness is enforced by the index
+		_, err := request.UpdateProfile(c.GetString(components.USERIDKEY), userColl)
+		if mongo.IsDuplicateKeyError(err){
+			c.JSON(http.StatusConflict, gin.H{"message":"handle already taken"})
+			c.Abort()
+			return
+		}
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully updated profile"})
+	}
+}
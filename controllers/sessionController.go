@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"net/http"
+	"rest-api/components"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Returns every device the user is signed in on
+*/
+func GetSessionsHandler(sessionColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving user id and session id from token verification
+		userId := c.GetString(components.USERIDKEY)
+		currentSessionId := c.GetString(components.SESSIONIDKEY)
+
+		sessions, err := models.GetUserSessions(userId, sessionColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Marking the session the request was made from
+		type sessionResponse struct{
+			models.Session
+			Current bool `json:"current"`
+		}
+		response := make([]sessionResponse, 0, len(sessions))
+		for _, session := range sessions{
+			response = append(response, sessionResponse{Session: session, Current: session.ID == currentSessionId})
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+/*
+Requires Query (id: sessionId)
+Signs the user out of a single device
+*/
+func RevokeSessionHandler(sessionColl, refreshColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving session id from request query
+		sessionId := c.Query("id")
+		if sessionId == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"unable to find 'id' in query"})
+			c.Abort()
+			return
+		}
+
+		// Retrieving user id from token verification
+		userId := c.GetString(components.USERIDKEY)
+
+		// Only sessions of the requesting user can be revoked
+		revoked, err := components.RevokeSession(sessionId, userId, sessionColl, refreshColl)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if !revoked{
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such session found"})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully revoked session"})
+	}
+}
+
+/*
+Logs the user out everywhere, including the requesting device, and revokes their api keys
+*/
+func RevokeAllSessionsHandler(sessionColl, refreshColl, apiKeyColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving user id from token verification
+		userId := c.GetString(components.USERIDKEY)
+
+		if err := components.RevokeAllSessions(userId, sessionColl, refreshColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if err := models.RevokeUserApiKeys(userId, apiKeyColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully signed out of all devices"})
+	}
+}
+
+/*
+Signs the user out of the requesting device
+Presented access token stops working right away instead of at its expiry,
+and the refresh tokens of its session can no longer be exchanged
+*/
+func SignOutHandler(sessionColl, refreshColl, auditColl *mongo.Collection, revocations components.RevocationStore) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving the access token already verified by the middleware
+		tokenString, err := components.GetBearerToken(c)
+		if err != nil{
+			c.JSON(http.StatusUnauthorized, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		claims, err := components.ParseAccessToken(tokenString)
+		if err != nil{
+			c.JSON(http.StatusUnauthorized, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Revoking the access token until it would have expired anyway
+		if err := revocations.Revoke(claims.ID, claims.ExpiresAt.Time); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Ending the session so that no new access token can be issued for it
+		if _, err := components.RevokeSession(claims.SessionId, claims.Subject, sessionColl, refreshColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		components.RecordAuditEvent(c, models.EVENTLOGOUT, models.OUTCOMESUCCESS, claims.Subject, map[string]string{"sessionId": claims.SessionId}, auditColl)
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully signed out"})
+	}
+}
@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+	"rest-api/components"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const(
+	MFACHALLENGEPURPOSE = "mfa"
+	MFACHALLENGEEXPIRY = time.Minute*5
+)
+
+// Name shown for the account in authenticator apps
+func getTOTPIssuer() string{
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != ""{
+		return issuer
+	}
+	return "Life Lessons"
+}
+
+/*
+Accepts either current TOTP code or one of the unused recovery codes
+*/
+func verifySecondFactor(user *models.User, code string, userColl *mongo.Collection)(bool, error){
+	if step, ok := components.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok{
+		return models.UseTOTPStep(user.ID, step, userColl)
+	}
+	codeHash := components.HashToken(components.NormalizeRecoveryCode(code))
+	return models.UseRecoveryCode(user.ID, codeHash, userColl)
+}
+
+/*
+Starts 2FA enrollment
+Returns secret and otpauth uri to be shown as QR code, 2FA is enabled only after confirmation
+*/
+func EnrollTOTPHandler(userColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving user from token verification
+		user, err := models.GetUserById(c.GetString(components.USERIDKEY), userColl)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+		if user.TOTPEnabled{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"2FA already enabled"})
+			c.Abort()
+			return
+		}
+
+		// Generating and saving new secret
+		secret, err := components.GenerateTOTPSecret()
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if _, err := models.SetPendingTOTPSecret(user.ID, secret, userColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret": secret,
+			"uri": components.TOTPURI(getTOTPIssuer(), user.Email, secret),
+		})
+	}
+}
+
+/*
+Enables 2FA once the user proves their app generates valid codes
+Returns recovery codes, they are never shown again
+*/
+func ConfirmTOTPHandler(userColl, attemptColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving code from request body
+		var body struct{
+			Code string `json:"code"`
+		}
+		if err := c.BindJSON(&body); err != nil || body.Code == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"code not provided"})
+			c.Abort()
+			return
+		}
+
+		// Retrieving user from token verification
+		user, err := models.GetUserById(c.GetString(components.USERIDKEY), userColl)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+		if user.TOTPEnabled{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"2FA already enabled"})
+			c.Abort()
+			return
+		}
+		if user.TOTPSecret == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"start 2FA enrollment first"})
+			c.Abort()
+			return
+		}
+
+		// Codes are short, so guessing is limited like signing in
+		mfaKey := mfaAttemptKey(user.ID)
+		if rejectIfLocked(c, attemptColl, mfaKey){
+			return
+		}
+
+		// Validating code against the pending secret
+		step, ok := components.ValidateTOTP(user.TOTPSecret, body.Code, time.Now())
+		if !ok{
+			if err := models.RecordLoginFailure(mfaKey, models.ACCOUNTLOCKOUT, attemptColl); err != nil{
+				log.Println("Unable to record failed 2FA confirmation:", err.Error())
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"message":"invalid code"})
+			c.Abort()
+			return
+		}
+		models.ClearLoginAttempts(mfaKey, attemptColl)
+
+		// Generating recovery codes and enabling 2FA
+		recoveryCodes, err := components.GenerateRecoveryCodes()
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		recoveryCodeHashes := make([]string, 0, len(recoveryCodes))
+		for _, code := range recoveryCodes{
+			recoveryCodeHashes = append(recoveryCodeHashes, components.HashToken(code))
+		}
+		if _, err := models.EnableTOTP(user.ID, recoveryCodeHashes, step, userColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully enabled 2FA", "recoveryCodes": recoveryCodes})
+	}
+}
+
+/*
+Disables 2FA, requires a valid code together with the password (code may then be a recovery code)
+Users signing in without password, through OIDC or magic links, prove themselves with a current TOTP code alone
+Failures count towards the same lockout as the second step of signing in
+*/
+func DisableTOTPHandler(userColl, attemptColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving password and code from request body
+		var body struct{
+			Password string `json:"password"`
+			Code string `json:"code"`
+		}
+		if err := c.BindJSON(&body); err != nil || body.Code == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"code is required"})
+			c.Abort()
+			return
+		}
+
+		// Retrieving user from token verification
+		user, err := models.GetUserById(c.GetString(components.USERIDKEY), userColl)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+		if !user.TOTPEnabled{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"2FA not enabled"})
+			c.Abort()
+			return
+		}
+
+		// Stolen access token alone mustn't be enough to guess the code
+		mfaKey := mfaAttemptKey(user.ID)
+		if rejectIfLocked(c, attemptColl, mfaKey){
+			return
+		}
+
+		// Verifying password when given, otherwise only a current TOTP code will do
+		var valid bool
+		if body.Password != ""{
+			if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)) == nil{
+				valid, err = verifySecondFactor(user, body.Code, userColl)
+			}
+		}else if step, ok := components.ValidateTOTP(user.TOTPSecret, body.Code, time.Now()); ok{
+			valid, err = models.UseTOTPStep(user.ID, step, userColl)
+		}
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if !valid{
+			if err := models.RecordLoginFailure(mfaKey, models.ACCOUNTLOCKOUT, attemptColl); err != nil{
+				log.Println("Unable to record failed 2FA disabling:", err.Error())
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"message":"wrong password or code"})
+			c.Abort()
+			return
+		}
+		models.ClearLoginAttempts(mfaKey, attemptColl)
+
+		if _, err := models.DisableTOTP(user.ID, userColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully disabled 2FA"})
+	}
+}
+
+/*
+Second step of signing in for users with 2FA
+Exchanges challenge token from first step and a valid code for tokens
+*/
+func LoginUserWithTOTPHandler(userColl, sessionColl, refreshColl, attemptColl, auditColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving challenge token and code from request body
+		var body struct{
+			ChallengeToken string `json:"challengeToken"`
+			Code string `json:"code"`
+		}
+		if err := c.BindJSON(&body); err != nil || body.ChallengeToken == "" || body.Code == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"challenge token and code are required"})
+			c.Abort()
+			return
+		}
+
+		// Verifying the challenge token issued after first factor
+		claims, err := components.ParseChallengeToken(body.ChallengeToken, MFACHALLENGEPURPOSE)
+		if err != nil{
+			c.JSON(http.StatusUnauthorized, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		user, err := models.GetUserById(claims.Subject, userColl)
+		if err != nil || !user.TOTPEnabled{
+			c.JSON(http.StatusUnauthorized, gin.H{"message":"invalid challenge token"})
+			c.Abort()
+			return
+		}
+
+		// User may have been suspended since the challenge was issued
+		if rejectIfRestricted(c, user){
+			components.RecordAuditEvent(c, models.EVENTLOGIN, models.OUTCOMEFAILURE, user.ID, map[string]string{"method": "totp", "reason": "account restricted"}, auditColl)
+			return
+		}
+
+		// Codes are short, so guessing is limited like passwords
+		mfaKey := mfaAttemptKey(user.ID)
+		if rejectIfLocked(c, attemptColl, mfaKey){
+			components.RecordAuditEvent(c, models.EVENTLOGIN, models.OUTCOMEFAILURE, user.ID, map[string]string{"method": "totp", "reason": "locked"}, auditColl)
+			return
+		}
+
+		// Verifying second factor
+		valid, err := verifySecondFactor(user, body.Code, userColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if !valid{
+			if err := models.RecordLoginFailure(mfaKey, models.ACCOUNTLOCKOUT, attemptColl); err != nil{
+				log.Println("Unable to record failed sign in:", err.Error())
+			}
+			components.RecordAuditEvent(c, models.EVENTLOGIN, models.OUTCOMEFAILURE, user.ID, map[string]string{"method": "totp", "reason": "invalid code"}, auditColl)
+			c.JSON(http.StatusUnauthorized, gin.H{"message":"invalid code"})
+			c.Abort()
+			return
+		}
+		models.ClearLoginAttempts(mfaKey, attemptColl)
+
+		// Generating new tokens
+		tokens, err := components.IssueTokens(c, user, "", sessionColl, refreshColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		components.RecordAuditEvent(c, models.EVENTLOGIN, models.OUTCOMESUCCESS, user.ID, map[string]string{"method": "totp"}, auditColl)
+		c.JSON(http.StatusOK, tokens)
+	}
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rest-api/components"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// Posts @body as the signed in user @userId
+func postAsUser(handler gin.HandlerFunc, userId string, body map[string]string) *httptest.ResponseRecorder{
+	router := gin.New()
+	router.POST("/", func(c *gin.Context){
+		c.Set(components.USERIDKEY, userId)
+	}, handler)
+	encoded, _ := json.Marshal(body)
+	request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encoded))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestDisableTOTPLockout(t *testing.T){
+	gin.SetMode(gin.TestMode)
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	id := primitive.NewObjectID()
+	user := bson.D{
+		{Key: "_id", Value: id},
+		{Key: "email", Value: "user@example.com"},
+		{Key: "totpEnabled", Value: true},
+		{Key: "totpSecret", Value: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+	}
+
+	mt.Run("wrong code counts as failure", func(mt *mtest.T){
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch, user),
+			mtest.CreateCursorResponse(0, "db.LoginAttempts", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: attemptDocument(mfaAttemptKey(id.Hex()), 1)}),
+		)
+		recorder := postAsUser(DisableTOTPHandler(mt.DB.Collection("Users"), mt.DB.Collection("LoginAttempts")), id.Hex(), map[string]string{"code": "000000"})
+		if recorder.Code != http.StatusUnauthorized{
+			mt.Fatalf("expected status 401, got %d", recorder.Code)
+		}
+		failure := mt.GetAllStartedEvents()[2]
+		if failure.CommandName != "findAndModify" || failure.Command.Lookup("query", "key").StringValue() != mfaAttemptKey(id.Hex()){
+			mt.Fatalf("expected failure of %s to be recorded, got %s %s", mfaAttemptKey(id.Hex()), failure.CommandName, failure.Command)
+		}
+	})
+
+	mt.Run("locked account", func(mt *mtest.T){
+		locked := append(attemptDocument(mfaAttemptKey(id.Hex()), 5), bson.E{Key: "lockedUntil", Value: time.Now().Add(time.Minute)})
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch, user),
+			mtest.CreateCursorResponse(0, "db.LoginAttempts", mtest.FirstBatch, locked),
+		)
+		recorder := postAsUser(DisableTOTPHandler(mt.DB.Collection("Users"), mt.DB.Collection("LoginAttempts")), id.Hex(), map[string]string{"code": "000000"})
+		if recorder.Code != http.StatusTooManyRequests{
+			mt.Fatalf("expected status 429, got %d", recorder.Code)
+		}
+		// Code isn't even checked until the lock ends
+		if count := len(mt.GetAllStartedEvents()); count != 2{
+			mt.Fatalf("expected only lookups, got %d commands", count)
+		}
+	})
+}
@@ -5,12 +5,12 @@ import (
 	"net/http"
 	"rest-api/components"
 	"rest-api/models"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Only for admin
@@ -53,7 +53,7 @@ func GetUserHandler(coll *mongo.Collection) gin.HandlerFunc{
 	}
 }
 
-func UpdateUserHandler(coll *mongo.Collection) gin.HandlerFunc{
+func UpdateUserHandler(userColl, sessionColl, refreshColl, apiKeyColl, auditColl *mongo.Collection) gin.HandlerFunc{
 	return func(c *gin.Context){
 
 		// Retrieving user id from token verification
@@ -81,7 +81,7 @@ func UpdateUserHandler(coll *mongo.Collection) gin.HandlerFunc{
 		}
 		var user models.User
 		filter := bson.M{"_id": id}
-		result := coll.FindOne(context.TODO(), filter)
+		result := userColl.FindOne(context.TODO(), filter)
 		err = result.Decode(&user)
 		if err != nil{
 			c.JSON(http.StatusBadRequest, gin.H{"message":"no such user with userid in token exists"})
@@ -91,20 +91,18 @@ func UpdateUserHandler(coll *mongo.Collection) gin.HandlerFunc{
 
 
 
-		hashedPassword := []byte("")
-		token := ""
+		hashedPassword := ""
 
 		if userData.Password != ""{
-			// Generating hashed password
-			hashedPassword, err = bcrypt.GenerateFromPassword([]byte(user.Password), models.COST)
-			if err != nil{
+			// Checking new password against the policy
+			if err := components.GetPasswordPolicy().Validate(userData.Password, user.Email, userData.Username); err != nil{
 				c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
 				c.Abort()
 				return
 			}
 
-			// Generating new token with updated email and password
-			token, err = components.GenerateJWTToken(user.IsAdmin, user.Email, string(hashedPassword))
+			// Generating hashed password
+			hashedPassword, err = components.HashPassword(userData.Password)
 			if err != nil{
 				c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
 				c.Abort()
@@ -112,23 +110,54 @@ func UpdateUserHandler(coll *mongo.Collection) gin.HandlerFunc{
 			}
 		}
 
-		// Updating user and last token
-		_ , err = userData.UpdateUser(userId.(string), string(hashedPassword), token, coll)
+		// Updating user
+		_ , err = userData.UpdateUser(userId.(string), hashedPassword, userColl)
 		if err!= nil{
 			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
 			c.Abort()
 			return
 		}
 
-		// Returns new token on updating only password
 		// Returns null token on updating username
-		c.JSON(http.StatusOK, gin.H{"token":token})
+		if userData.Password == ""{
+			c.JSON(http.StatusOK, gin.H{"token":""})
+			return
+		}
+
+		components.RecordAuditEvent(c, models.EVENTPASSWORDCHANGE, models.OUTCOMESUCCESS, user.ID, map[string]string{"method": "update"}, auditColl)
+
+		// Password changed, so user is signed out of every device
+		// and new tokens are generated with the updated password
+		if err := components.RevokeAllSessions(user.ID, sessionColl, refreshColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if err := models.RevokeUserApiKeys(user.ID, apiKeyColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		user.Password = hashedPassword
+		tokens, err := components.IssueTokens(c, &user, "", sessionColl, refreshColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Returns new tokens on updating password
+		c.JSON(http.StatusOK, tokens)
 	}
 }
 
 
-// Requires Query (id: userId)
-func DeleteUserHandler(pllColl *mongo.Collection) gin.HandlerFunc{
+/*
+Schedules deletion of requesting user after the grace period and signs them out everywhere
+Signing in alone doesn't keep the account, the user has to sign in and
+call POST /user/cancelDeletion within the grace period
+*/
+func DeleteUserHandler(userColl, sessionColl, refreshColl, apiKeyColl, auditColl *mongo.Collection) gin.HandlerFunc{
 	return func(c *gin.Context){
 
 		// Retreiving userid from token verification
@@ -138,19 +167,95 @@ func DeleteUserHandler(pllColl *mongo.Collection) gin.HandlerFunc{
 			c.Abort()
 			return
 		}
+		user, err := models.GetUserById(userId, userColl)
+		if err != nil{
+			c.JSON(http.StatusNotFound, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
 
-		// Deleting the user with userId
-		deleteResult , err := models.DeleteUser(userId, pllColl)
-		if err!= nil{
+		// Deletion already asked for keeps its original date
+		if user.IsPendingDeletion(){
+			c.JSON(http.StatusOK, gin.H{"message":"User is already scheduled for deletion", "deletionScheduledOn": user.DeletionScheduledOn})
+			return
+		}
+
+		gracePeriod, err := components.GetDeletionGracePeriod()
+		if err != nil{
 			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
 			c.Abort()
 			return
 		}
-		if deleteResult.DeletedCount == 0 {
-			c.JSON(http.StatusInternalServerError, gin.H{"message":"Unable to delete user!"})
+		scheduledOn := time.Now().Add(gracePeriod)
+		if _, err := models.ScheduleUserDeletion(userId, scheduledOn, userColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
 			c.Abort()
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"message":"Successfully deleted User!"})
+		if err := components.RevokeAllSessions(userId, sessionColl, refreshColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if err := models.RevokeUserApiKeys(userId, apiKeyColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		components.RecordAuditEvent(c, models.EVENTACCOUNTDELETION, models.OUTCOMESUCCESS, userId, map[string]string{"action": "schedule"}, auditColl)
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully scheduled user for deletion", "deletionScheduledOn": scheduledOn})
 	} 
-}
\ No newline at end of file
+}
+
+/*
+Keeps the account of requesting user if its grace period hasn't ended yet
+*/
+func CancelUserDeletionHandler(userColl, auditColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		userId := c.GetString(components.USERIDKEY)
+		result, err := models.CancelUserDeletion(userId, userColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if result.MatchedCount == 0{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"user is not scheduled for deletion"})
+			c.Abort()
+			return
+		}
+		components.RecordAuditEvent(c, models.EVENTACCOUNTDELETION, models.OUTCOMESUCCESS, userId, map[string]string{"action": "cancel"}, auditColl)
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully cancelled deletion of user"})
+	}
+}
+
+/*
+Creates verified user whose email was proven by other means, e.g. external provider or magic link
+*/
+func createUserWithoutPassword(username, email, photo string, userColl *mongo.Collection)(*models.User, error){
+
+	// Random password nobody knows, user can set one through password reset
+	password, err := components.GenerateOpaqueToken()
+	if err != nil{
+		return nil, err
+	}
+	hashedPassword, err := components.HashPassword(password)
+	if err != nil{
+		return nil, err
+	}
+
+	request := models.UserRequest{
+		Username: username,
+		Email: email,
+		Password: hashedPassword,
+		Photo: photo,
+	}
+	newUser := request.ToUserIntermediate(models.ROLEUSER)
+	newUser.Verified = true
+	result, err := newUser.AddUser(userColl)
+	if err != nil{
+		return nil, err
+	}
+	return models.GetUserById(result.InsertedID.(primitive.ObjectID).Hex(), userColl)
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"net/http"
+	"rest-api/components"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Requires Query (id: userId)
+Blocked user and requesting user stop following each other and can't follow again
+Lessons of either are left out of feed of the other
+*/
+func BlockUserHandler(userColl, followColl, relationColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		targetId, ok := addUserRelation(c, models.RELATIONBLOCK, userColl, relationColl)
+		if !ok{
+			return
+		}
+		if err := models.RemoveFollowsBetween(c.GetString(components.USERIDKEY), targetId, followColl); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully blocked user", "isBlocked": true})
+	}
+}
+
+/*
+Requires Query (id: userId)
+*/
+func UnblockUserHandler(relationColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		if removeUserRelation(c, models.RELATIONBLOCK, relationColl){
+			c.JSON(http.StatusOK, gin.H{"message":"Successfully unblocked user", "isBlocked": false})
+		}
+	}
+}
+
+/*
+Requires Query (id: userId)
+Lessons of muted user are left out of feed of requesting user, follow is kept
+*/
+func MuteUserHandler(userColl, relationColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		if _, ok := addUserRelation(c, models.RELATIONMUTE, userColl, relationColl); ok{
+			c.JSON(http.StatusOK, gin.H{"message":"Successfully muted user", "isMuted": true})
+		}
+	}
+}
+
+/*
+Requires Query (id: userId)
+*/
+func UnmuteUserHandler(relationColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		if removeUserRelation(c, models.RELATIONMUTE, relationColl){
+			c.JSON(http.StatusOK, gin.H{"message":"Successfully unmuted user", "isMuted": false})
+		}
+	}
+}
+
+// Returns users blocked by the requesting user
+func GetBlockedUsersHandler(userColl, relationColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		respondWithUserRelations(c, models.RELATIONBLOCK, userColl, relationColl)
+	}
+}
+
+// Returns users muted by the requesting user
+func GetMutedUsersHandler(userColl, relationColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+		respondWithUserRelations(c, models.RELATIONMUTE, userColl, relationColl)
+	}
+}
+
+/*
+Adds @kind relation from requesting user to the user in query
+Returns id of the target, false when response has already been written
+*/
+func addUserRelation(c *gin.Context, kind models.RelationKind, userColl, relationColl *mongo.Collection)(string, bool){
+
+	// Retrieving target user from query
+	targetId := c.Query("id")
+	if targetId == ""{
+		c.JSON(http.StatusBadRequest, gin.H{"message":"unable to find 'id' in query"})
+		c.Abort()
+		return "", false
+	}
+	userId := c.GetString(components.USERIDKEY)
+	if userId == targetId{
+		c.JSON(http.StatusBadRequest, gin.H{"message":"cannot " + string(kind) + " yourself"})
+		c.Abort()
+		return "", false
+	}
+	target, err := models.GetUserById(targetId, userColl)
+	if err != nil{
+		c.JSON(http.StatusNotFound, gin.H{"message":"no such user exists"})
+		c.Abort()
+		return "", false
+	}
+
+	if err := models.AddUserRelation(userId, target.ID, kind, relationColl); err != nil{
+		c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+		c.Abort()
+		return "", false
+	}
+	return target.ID, true
+}
+
+// Returns false when response has already been written
+func removeUserRelation(c *gin.Context, kind models.RelationKind, relationColl *mongo.Collection) bool{
+
+	// Retrieving target user from query
+	targetId := c.Query("id")
+	if targetId == ""{
+		c.JSON(http.StatusBadRequest, gin.H{"message":"unable to find 'id' in query"})
+		c.Abort()
+		return false
+	}
+
+	if _, err := models.RemoveUserRelation(c.GetString(components.USERIDKEY), targetId, kind, relationColl); err != nil{
+		c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+func respondWithUserRelations(c *gin.Context, kind models.RelationKind, userColl, relationColl *mongo.Collection){
+	relations, err := models.GetUserRelations(c.GetString(components.USERIDKEY), kind, relationColl)
+	if err != nil{
+		c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+		c.Abort()
+		return
+	}
+
+	// Retrieving the targets, deleted users are left out
+	userIds := make([]string, 0, len(relations))
+	for _, relation := range relations{
+		userIds = append(userIds, relation.TargetId.Hex())
+	}
+	users, _ := models.GetUsersById(userIds, userColl)
+	usersById := make(map[string]models.User, len(users))
+	for _, user := range users{
+		usersById[user.ID] = user
+	}
+	summaries := make([]models.UserSummary, 0, len(relations))
+	for _, userId := range userIds{
+		if user, ok := usersById[userId]; ok{
+			summaries = append(summaries, user.ToUserSummary())
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"users": summaries})
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+	"rest-api/components"
+	"rest-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const VERIFICATIONTOKENEXPIRY = time.Hour*24
+
+/*
+Sends link for verifying the current email of the user
+Previously sent links stop working
+*/
+func sendVerificationEmail(user *models.User, tokenColl *mongo.Collection, mailer components.Mailer) error{
+	if err := models.InvalidateOneTimeTokens(user.ID, models.PURPOSEEMAILVERIFICATION, tokenColl); err != nil{
+		return err
+	}
+	data := map[string]string{"email": user.Email}
+	token, err := components.IssueOneTimeToken(user.ID, models.PURPOSEEMAILVERIFICATION, VERIFICATIONTOKENEXPIRY, data, tokenColl)
+	if err != nil{
+		return err
+	}
+	link := components.GetPublicURL() + "/v1/user/verifyEmail?token=" + url.QueryEscape(token)
+	return mailer.Send(components.Mail{
+		To: user.Email,
+		Subject: "Verify your email",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"Open the following link to verify your email, it is valid for 24 hours:\n" +
+			link + "\n\n" +
+			"If you didn't sign up, you can ignore this email.\n",
+	})
+}
+
+/*
+Requires Query (token: verification token)
+Opened from the link in verification email
+*/
+func VerifyEmailHandler(userColl, tokenColl *mongo.Collection) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving verification token from query
+		token := c.Query("token")
+		if token == ""{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"unable to find 'token' in query"})
+			c.Abort()
+			return
+		}
+
+		// Consuming the token
+		verificationToken, err := models.UseOneTimeToken(components.HashToken(token), models.PURPOSEEMAILVERIFICATION, tokenColl)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Verifying the email the token was sent to
+		result, err := models.SetUserVerified(verificationToken.UserId, verificationToken.Data["email"], userColl)
+		if err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		if result.MatchedCount == 0{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"email has changed since the link was sent"})
+			c.Abort()
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message":"Successfully verified email"})
+	}
+}
+
+/*
+Sends a new verification email to the requesting user
+*/
+func ResendVerificationHandler(userColl, tokenColl *mongo.Collection, mailer components.Mailer) gin.HandlerFunc{
+	return func(c *gin.Context){
+
+		// Retrieving user from token verification
+		user, err := models.GetUserById(c.GetString(components.USERIDKEY), userColl)
+		if err != nil{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"no such user exists"})
+			c.Abort()
+			return
+		}
+		if user.Verified{
+			c.JSON(http.StatusBadRequest, gin.H{"message":"email already verified"})
+			c.Abort()
+			return
+		}
+
+		if err := sendVerificationEmail(user, tokenColl, mailer); err != nil{
+			c.JSON(http.StatusInternalServerError, gin.H{"message":err.Error()})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message":"Verification email sent"})
+	}
+}
@@ -4,66 +4,146 @@ import (
 	"context"
 	"log"
 	"os"
+	"rest-api/components"
 	"rest-api/controllers"
 	"rest-api/middlewares"
+	"rest-api/models"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func setupRouter(db *mongo.Database) *gin.Engine{
+func setupRouter(db *mongo.Database, mailer components.Mailer, revocations components.RevocationStore, oidcProviders map[string]*components.OIDCProvider, feedRanker components.FeedRanker, exporter *components.DataExporter) *gin.Engine{
 	// gin.SetMode(gin.ReleaseMode)
 	parentRouter := gin.Default()
 	
+	parentRouter.GET("/.well-known/jwks.json", controllers.GetJWKSHandler())
+
 	router := parentRouter.Group("/v1")
 
 	userCollection := db.Collection("Users")
 	pllCollection := db.Collection("Pll")	
 	commentCollection := db.Collection("Comments")
 	categoryCollection := db.Collection("Categories")
+	refreshTokenCollection := db.Collection("RefreshTokens")
+	sessionCollection := db.Collection("Sessions")
+	roleChangeCollection := db.Collection("RoleChanges")
+	oneTimeTokenCollection := db.Collection("OneTimeTokens")
+	externalIdentityCollection := db.Collection("ExternalIdentities")
+	loginAttemptCollection := db.Collection("LoginAttempts")
+	apiKeyCollection := db.Collection("ApiKeys")
+	auditEventCollection := db.Collection("AuditEvents")
+	followCollection := db.Collection("Follows")
+	categorySubscriptionCollection := db.Collection("CategorySubscriptions")
+	userRelationCollection := db.Collection("UserRelations")
+	dataExportCollection := db.Collection("DataExports")
+
+	auth := middlewares.NewAuthorizer(userCollection, sessionCollection, apiKeyCollection, auditEventCollection, revocations)
 
 	user := router.Group("/user")
 	{
-		// Without any middleware
-		user.POST("/signUp", controllers.SignUpUserHandler(userCollection))
-		user.POST("/signInWithPassword", controllers.LoginUserWithPasswordHandler(userCollection))
+		// Without any authorization
+		user.POST("/signUp", controllers.SignUpUserHandler(userCollection, sessionCollection, refreshTokenCollection, oneTimeTokenCollection, auditEventCollection, mailer))
+		user.POST("/signInWithPassword", controllers.LoginUserWithPasswordHandler(userCollection, sessionCollection, refreshTokenCollection, loginAttemptCollection, auditEventCollection))
+		user.POST("/signIn", controllers.LoginUserWithTokenHandler(userCollection, sessionCollection, refreshTokenCollection, auditEventCollection))
+		user.POST("/signInWithTOTP", controllers.LoginUserWithTOTPHandler(userCollection, sessionCollection, refreshTokenCollection, loginAttemptCollection, auditEventCollection))
+		user.POST("/magicLink", controllers.RequestMagicLinkHandler(userCollection, oneTimeTokenCollection, loginAttemptCollection, mailer))
+		user.POST("/signInWithMagicLink", controllers.LoginUserWithMagicLinkHandler(userCollection, sessionCollection, refreshTokenCollection, oneTimeTokenCollection, auditEventCollection))
+		user.GET("/verifyEmail", controllers.VerifyEmailHandler(userCollection, oneTimeTokenCollection))
+		user.POST("/forgotPassword", controllers.ForgotPasswordHandler(userCollection, oneTimeTokenCollection, mailer))
+		user.POST("/resetPassword", controllers.ResetPasswordHandler(userCollection, sessionCollection, refreshTokenCollection, apiKeyCollection, oneTimeTokenCollection, loginAttemptCollection, auditEventCollection))
+		user.POST("/confirmEmailChange", controllers.ConfirmEmailChangeHandler(userCollection, sessionCollection, refreshTokenCollection, apiKeyCollection, oneTimeTokenCollection, auditEventCollection, mailer))
+		user.POST("/cancelEmailChange", controllers.CancelEmailChangeHandler(sessionCollection, refreshTokenCollection, apiKeyCollection, oneTimeTokenCollection, auditEventCollection))
+		user.GET("/dataExport/download", controllers.DownloadDataExportHandler(dataExportCollection, oneTimeTokenCollection, exporter.Archives))
+
+		// Managing own account
+		// Security sensitive actions are not available while impersonating
+		user.POST("/signOut", auth.Require(components.PERMACCOUNTMANAGE), controllers.SignOutHandler(sessionCollection, refreshTokenCollection, auditEventCollection, revocations))
+		user.PATCH("/", auth.Require(components.PERMACCOUNTSECURITY), controllers.UpdateUserHandler(userCollection, sessionCollection, refreshTokenCollection, apiKeyCollection, auditEventCollection))
+		user.PATCH("/profile", auth.Require(components.PERMACCOUNTMANAGE), controllers.UpdateProfileHandler(userCollection))
+		user.POST("/changeEmail", auth.Require(components.PERMACCOUNTSECURITY), controllers.RequestEmailChangeHandler(userCollection, sessionCollection, oneTimeTokenCollection, loginAttemptCollection, auditEventCollection, mailer))
+		user.POST("/resendVerification", auth.Require(components.PERMACCOUNTMANAGE), controllers.ResendVerificationHandler(userCollection, oneTimeTokenCollection, mailer))
+		user.POST("/2fa/enroll", auth.Require(components.PERMACCOUNTSECURITY), controllers.EnrollTOTPHandler(userCollection))
+		user.POST("/2fa/confirm", auth.Require(components.PERMACCOUNTSECURITY), controllers.ConfirmTOTPHandler(userCollection, loginAttemptCollection))
+		user.POST("/2fa/disable", auth.Require(components.PERMACCOUNTSECURITY), controllers.DisableTOTPHandler(userCollection, loginAttemptCollection))
+		user.GET("/sessions", auth.Require(components.PERMACCOUNTMANAGE), controllers.GetSessionsHandler(sessionCollection))
+		user.DELETE("/session", auth.Require(components.PERMACCOUNTSECURITY), controllers.RevokeSessionHandler(sessionCollection, refreshTokenCollection))
+		user.DELETE("/sessions", auth.Require(components.PERMACCOUNTSECURITY), controllers.RevokeAllSessionsHandler(sessionCollection, refreshTokenCollection, apiKeyCollection))
+		user.POST("/apiKeys", auth.Require(components.PERMACCOUNTSECURITY), controllers.AddApiKeyHandler(apiKeyCollection))
+		user.GET("/apiKeys", auth.Require(components.PERMACCOUNTMANAGE), controllers.GetApiKeysHandler(apiKeyCollection))
+		user.DELETE("/apiKey", auth.Require(components.PERMACCOUNTSECURITY), controllers.RevokeApiKeyHandler(apiKeyCollection))
+		user.POST("/dataExport", auth.Require(components.PERMACCOUNTSECURITY), controllers.RequestDataExportHandler(exporter, oneTimeTokenCollection))
+		user.GET("/dataExport", auth.Require(components.PERMACCOUNTMANAGE), controllers.GetDataExportHandler(dataExportCollection))
+		user.GET("/securityActivity", auth.Require(components.PERMACCOUNTMANAGE), controllers.GetSecurityActivityHandler(auditEventCollection))
+		user.DELETE("/", auth.Require(components.PERMACCOUNTSECURITY), controllers.DeleteUserHandler(userCollection, sessionCollection, refreshTokenCollection, apiKeyCollection, auditEventCollection))
+		user.POST("/cancelDeletion", auth.Require(components.PERMACCOUNTSECURITY), controllers.CancelUserDeletionHandler(userCollection, auditEventCollection))
+
+		user.GET("/profile", auth.Optional(components.PERMPLLREAD), controllers.GetProfileHandler(userCollection, pllCollection, followCollection))
+		user.GET("/followers", auth.Optional(components.PERMPLLREAD), controllers.GetFollowersHandler(userCollection, followCollection))
+		user.GET("/following", auth.Optional(components.PERMPLLREAD), controllers.GetFollowingHandler(userCollection, followCollection))
+		user.POST("/follow", auth.Require(components.PERMUSERFOLLOW), controllers.FollowUserHandler(userCollection, followCollection, userRelationCollection))
+		user.DELETE("/follow", auth.Require(components.PERMUSERFOLLOW), controllers.UnfollowUserHandler(followCollection))
+		user.POST("/block", auth.Require(components.PERMACCOUNTMANAGE), controllers.BlockUserHandler(userCollection, followCollection, userRelationCollection))
+		user.DELETE("/block", auth.Require(components.PERMACCOUNTMANAGE), controllers.UnblockUserHandler(userRelationCollection))
+		user.GET("/blocked", auth.Require(components.PERMACCOUNTMANAGE), controllers.GetBlockedUsersHandler(userCollection, userRelationCollection))
+		user.POST("/mute", auth.Require(components.PERMACCOUNTMANAGE), controllers.MuteUserHandler(userCollection, userRelationCollection))
+		user.DELETE("/mute", auth.Require(components.PERMACCOUNTMANAGE), controllers.UnmuteUserHandler(userRelationCollection))
+		user.GET("/muted", auth.Require(components.PERMACCOUNTMANAGE), controllers.GetMutedUsersHandler(userCollection, userRelationCollection))
+		user.GET("/", auth.Require(components.PERMUSERREAD), controllers.GetUsersHandler(userCollection))
+	}
 
-		// Requires User middleware
-		user.POST("/signIn", middlewares.UserAuthMiddlwareHandler(userCollection),controllers.LoginUserWithTokenHandler(userCollection))
-		user.PATCH("/", middlewares.UserAuthMiddlwareHandler(userCollection),controllers.UpdateUserHandler(userCollection))
-		user.DELETE("/", middlewares.UserAuthMiddlwareHandler(userCollection),controllers.DeleteUserHandler(userCollection))
+	oidc := router.Group("/auth/oidc")
+	{
+		oidc.GET("/providers", controllers.GetOIDCProvidersHandler(oidcProviders))
+		oidc.GET("/:provider/login", controllers.OIDCLoginHandler(oidcProviders, oneTimeTokenCollection))
+		oidc.GET("/:provider/callback", controllers.OIDCCallbackHandler(oidcProviders, userCollection, sessionCollection, refreshTokenCollection, oneTimeTokenCollection, externalIdentityCollection, auditEventCollection))
+	}
 
-		// Only for admin
-		user.GET("/", middlewares.AdminAuthMiddlwareHandler(userCollection),controllers.GetUsersHandler(userCollection))
+	admin := router.Group("/admin")
+	{
+		admin.PATCH("/user/role", auth.Require(components.PERMUSERROLE), controllers.UpdateUserRoleHandler(userCollection, roleChangeCollection, auditEventCollection))
+		admin.GET("/roleChanges", auth.Require(components.PERMUSERROLE), controllers.GetRoleChangesHandler(roleChangeCollection))
+		admin.POST("/user/unlock", auth.Require(components.PERMUSERMANAGE), controllers.UnlockUserHandler(userCollection, loginAttemptCollection))
+		admin.POST("/user/suspend", auth.Require(components.PERMUSERMANAGE), controllers.SuspendUserHandler(userCollection, auditEventCollection))
+		admin.POST("/user/ban", auth.Require(components.PERMUSERMANAGE), controllers.BanUserHandler(userCollection, sessionCollection, refreshTokenCollection, apiKeyCollection, auditEventCollection))
+		admin.POST("/user/reinstate", auth.Require(components.PERMUSERMANAGE), controllers.ReinstateUserHandler(userCollection, auditEventCollection))
+		admin.POST("/user/impersonate", auth.Require(components.PERMUSERIMPERSONATE), controllers.ImpersonateUserHandler(userCollection, auditEventCollection))
+		admin.GET("/auditEvents", auth.Require(components.PERMAUDITREAD), controllers.GetAuditEventsHandler(auditEventCollection))
 	}
 
-	pll := router.Group("/pll", middlewares.UserAuthMiddlwareHandler(userCollection))
+	pll := router.Group("/pll")
 	{
-		pll.GET("/plls", controllers.GetPllsHandler(pllCollection))
-		pll.GET("/pll", controllers.GetPllHandler(pllCollection))
-		pll.PATCH("/", controllers.UpdatePllHandler(pllCollection, userCollection, categoryCollection))
-		pll.POST("/", controllers.AddPllHandler(pllCollection,userCollection, categoryCollection))
-		pll.POST("/like", controllers.LikePllsHandler(pllCollection))
-		pll.POST("/dislike", controllers.DislikePllsHandler(pllCollection))
-		pll.DELETE("/", controllers.DeletePllHandler(pllCollection))
+		pll.GET("/plls", auth.Require(components.PERMPLLREAD), controllers.GetPllsHandler(pllCollection, userCollection))
+		pll.GET("/pll", auth.Require(components.PERMPLLREAD), controllers.GetPllHandler(pllCollection))
+		pll.PATCH("/", auth.Require(components.PERMPLLWRITE), controllers.UpdatePllHandler(pllCollection, userCollection, categoryCollection))
+		pll.POST("/", auth.Require(components.PERMPLLWRITE), controllers.AddPllHandler(pllCollection,userCollection, categoryCollection))
+		pll.POST("/like", auth.Require(components.PERMPLLLIKE), controllers.LikePllsHandler(pllCollection))
+		pll.POST("/dislike", auth.Require(components.PERMPLLLIKE), controllers.DislikePllsHandler(pllCollection))
+		pll.DELETE("/", auth.Require(components.PERMPLLWRITE), controllers.DeletePllHandler(pllCollection))
 	}
 
 	category := router.Group("/category")
 	{
-		category.GET("/categories",middlewares.UserAuthMiddlwareHandler(userCollection), controllers.GetCategoriesHandler(categoryCollection))
-		category.GET("/category", middlewares.UserAuthMiddlwareHandler(userCollection), controllers.GetCategoryHandler(categoryCollection))
-		category.POST("/", middlewares.AdminAuthMiddlwareHandler(userCollection),controllers.AddCategoryHandler(categoryCollection))
-		category.DELETE("/", middlewares.AdminAuthMiddlwareHandler(userCollection),controllers.DeleteCategoryHandler(categoryCollection))
-		category.PATCH("/", middlewares.AdminAuthMiddlwareHandler(userCollection),controllers.UpdateCategoryHandler(categoryCollection))
+		category.GET("/categories", auth.Require(components.PERMCATEGORYREAD), controllers.GetCategoriesHandler(categoryCollection))
+		category.GET("/category", auth.Require(components.PERMCATEGORYREAD), controllers.GetCategoryHandler(categoryCollection))
+		category.POST("/", auth.Require(components.PERMCATEGORYWRITE), controllers.AddCategoryHandler(categoryCollection))
+		category.DELETE("/", auth.Require(components.PERMCATEGORYWRITE), controllers.DeleteCategoryHandler(categoryCollection))
+		category.PATCH("/", auth.Require(components.PERMCATEGORYWRITE), controllers.UpdateCategoryHandler(categoryCollection))
+		category.GET("/subscriptions", auth.Require(components.PERMUSERFOLLOW), controllers.GetCategorySubscriptionsHandler(categorySubscriptionCollection))
+		category.POST("/subscription", auth.Require(components.PERMUSERFOLLOW), controllers.SubscribeCategoryHandler(categoryCollection, categorySubscriptionCollection))
+		category.DELETE("/subscription", auth.Require(components.PERMUSERFOLLOW), controllers.UnsubscribeCategoryHandler(categorySubscriptionCollection))
 	}
 
-	comments := router.Group("/comment", middlewares.UserAuthMiddlwareHandler(userCollection))
+	feed := components.NewHomeFeed(feedRanker, pllCollection, userCollection, followCollection, categorySubscriptionCollection, userRelationCollection)
+	router.GET("/feed", auth.Require(components.PERMPLLREAD), controllers.GetFeedHandler(feed))
+
+	comments := router.Group("/comment")
 	{
-		comments.GET("/", controllers.GetCommentsHandler(pllCollection, commentCollection))
-		comments.POST("/", controllers.AddCommentHandler(pllCollection,commentCollection, userCollection))
-		comments.DELETE("/", controllers.DeleteCommentHandler(pllCollection,commentCollection))
-		comments.PATCH("/", controllers.UpdateCommentHandler(commentCollection))
+		comments.GET("/", auth.Require(components.PERMCOMMENTREAD), controllers.GetCommentsHandler(pllCollection, commentCollection, userCollection))
+		comments.POST("/", auth.Require(components.PERMCOMMENTWRITE), controllers.AddCommentHandler(pllCollection,commentCollection, userCollection))
+		comments.DELETE("/", auth.Require(components.PERMCOMMENTWRITE), controllers.DeleteCommentHandler(pllCollection,commentCollection))
+		comments.PATCH("/", auth.Require(components.PERMCOMMENTWRITE), controllers.UpdateCommentHandler(commentCollection))
 	}
 
 	return parentRouter
@@ -88,6 +168,70 @@ func ConnectToMongo() *mongo.Client{
 	return client
 }
 
+func EnsureIndexes(db *mongo.Database){
+	if err := models.EnsureUserIndexes(db.Collection("Users")); err != nil{
+		log.Fatal("Cannot create user indexes: ", err.Error())
+	}
+	if err := models.EnsurePllIndexes(db.Collection("Pll")); err != nil{
+		log.Fatal("Cannot create personal life lesson indexes: ", err.Error())
+	}
+	if err := models.EnsureRefreshTokenIndexes(db.Collection("RefreshTokens")); err != nil{
+		log.Fatal("Cannot create refresh token indexes: ", err.Error())
+	}
+	if err := models.EnsureSessionIndexes(db.Collection("Sessions")); err != nil{
+		log.Fatal("Cannot create session indexes: ", err.Error())
+	}
+	if err := models.EnsureOneTimeTokenIndexes(db.Collection("OneTimeTokens")); err != nil{
+		log.Fatal("Cannot create one time token indexes: ", err.Error())
+	}
+	if err := models.EnsureExternalIdentityIndexes(db.Collection("ExternalIdentities")); err != nil{
+		log.Fatal("Cannot create external identity indexes: ", err.Error())
+	}
+	if err := models.EnsureLoginAttemptIndexes(db.Collection("LoginAttempts")); err != nil{
+		log.Fatal("Cannot create login attempt indexes: ", err.Error())
+	}
+	if err := models.EnsureApiKeyIndexes(db.Collection("ApiKeys")); err != nil{
+		log.Fatal("Cannot create api key indexes: ", err.Error())
+	}
+	if err := models.EnsureAuditEventIndexes(db.Collection("AuditEvents")); err != nil{
+		log.Fatal("Cannot create audit event indexes: ", err.Error())
+	}
+	if err := models.EnsureRevokedTokenIndexes(db.Collection("RevokedTokens")); err != nil{
+		log.Fatal("Cannot create revoked token indexes: ", err.Error())
+	}
+	if err := models.EnsureFollowIndexes(db.Collection("Follows")); err != nil{
+		log.Fatal("Cannot create follow indexes: ", err.Error())
+	}
+	if err := models.EnsureCategorySubscriptionIndexes(db.Collection("CategorySubscriptions")); err != nil{
+		log.Fatal("Cannot create category subscription indexes: ", err.Error())
+	}
+	if err := models.EnsureUserRelationIndexes(db.Collection("UserRelations")); err != nil{
+		log.Fatal("Cannot create user relation indexes: ", err.Error())
+	}
+	if err := models.EnsureDataExportIndexes(db.Collection("DataExports")); err != nil{
+		log.Fatal("Cannot create data export indexes: ", err.Error())
+	}
+	dataExportArchives, err := models.NewDataExportArchives(db)
+	if err != nil{
+		log.Fatal("Cannot open data export archives: ", err.Error())
+	}
+	if err := models.EnsureDataExportArchiveIndexes(dataExportArchives); err != nil{
+		log.Fatal("Cannot create data export archive indexes: ", err.Error())
+	}
+}
+
+func RunMigrations(db *mongo.Database){
+	if err := models.MarkLegacyUsersVerified(db.Collection("Users")); err != nil{
+		log.Fatal("Cannot mark existing users verified: ", err.Error())
+	}
+	if err := models.NormalizeLegacyEmails(db.Collection("Users")); err != nil{
+		log.Fatal("Cannot normalize existing emails: ", err.Error())
+	}
+	if err := models.SetMissingSessionExpiry(components.REFRESHTOKENEXPIRY, db.Collection("Sessions")); err != nil{
+		log.Fatal("Cannot set expiry of existing sessions: ", err.Error())
+	}
+}
+
 func DisconnectFromMongo(client *mongo.Client){
 	if err := client.Disconnect(context.TODO()); err != nil{
 		log.Fatal(err.Error())
@@ -102,10 +246,87 @@ func LoadEnv(){
 
 func main(){
 	LoadEnv()
+	if err := components.LoadKeySet(); err != nil{
+		log.Fatal("Cannot load JWT signing keys: ", err.Error())
+	}
+	if err := components.LoadPasswordPolicy(); err != nil{
+		log.Fatal("Cannot load password policy: ", err.Error())
+	}
+	if _, err := components.GetPseudonymSecret(); err != nil{
+		log.Fatal("Cannot load pseudonym secret: ", err.Error())
+	}
 	client := ConnectToMongo()
 	defer DisconnectFromMongo(client)
 	db := ConnectToDatabase(client)
+	EnsureIndexes(db)
+	RunMigrations(db)
+
+	// Creating first admin instead of running the server
+	// Usage: app bootstrap-admin
+	if len(os.Args) > 1 && os.Args[1] == "bootstrap-admin"{
+		if err := BootstrapAdmin(db); err != nil{
+			log.Fatal("Cannot bootstrap admin: ", err.Error())
+		}
+		return
+	}
+
+	mailer, err := components.NewMailerFromEnv()
+	if err != nil{
+		log.Fatal("Cannot create mailer: ", err.Error())
+	}
+
+	revocations, err := components.NewRevocationStoreFromEnv(db.Collection("RevokedTokens"))
+	if err != nil{
+		log.Fatal("Cannot create revocation store: ", err.Error())
+	}
+
+	oidcProviders, err := components.LoadOIDCProviders()
+	if err != nil{
+		log.Fatal("Cannot load OIDC providers: ", err.Error())
+	}
+
+	if _, err := components.GetDeletionGracePeriod(); err != nil{
+		log.Fatal("Cannot load account deletion grace period: ", err.Error())
+	}
+	dataExportArchives, err := models.NewDataExportArchives(db)
+	if err != nil{
+		log.Fatal("Cannot open data export archives: ", err.Error())
+	}
+	purger := &components.AccountPurger{
+		UserColl: db.Collection("Users"),
+		PllColl: db.Collection("Pll"),
+		CommentColl: db.Collection("Comments"),
+		FollowColl: db.Collection("Follows"),
+		RelationColl: db.Collection("UserRelations"),
+		SubscriptionColl: db.Collection("CategorySubscriptions"),
+		SessionColl: db.Collection("Sessions"),
+		RefreshColl: db.Collection("RefreshTokens"),
+		ApiKeyColl: db.Collection("ApiKeys"),
+		TokenColl: db.Collection("OneTimeTokens"),
+		ExternalIdentityColl: db.Collection("ExternalIdentities"),
+		DataExportColl: db.Collection("DataExports"),
+		DataExportArchives: dataExportArchives,
+		AuditColl: db.Collection("AuditEvents"),
+	}
+	purger.Start(components.ACCOUNTPURGEINTERVAL)
+
+	exporter := &components.DataExporter{
+		UserColl: db.Collection("Users"),
+		PllColl: db.Collection("Pll"),
+		CommentColl: db.Collection("Comments"),
+		SessionColl: db.Collection("Sessions"),
+		AuditColl: db.Collection("AuditEvents"),
+		ExportColl: db.Collection("DataExports"),
+		Archives: dataExportArchives,
+		Mailer: mailer,
+	}
+	exporter.Start(components.DATAEXPORTCLEANUPINTERVAL)
+
+	feedRanker, err := components.NewFeedRankerFromEnv(db.Collection("Pll"))
+	if err != nil{
+		log.Fatal("Cannot create feed ranker: ", err.Error())
+	}
 
-	router := setupRouter(db)
+	router := setupRouter(db, mailer, revocations, oidcProviders, feedRanker, exporter)
 	router.Run(os.Getenv("BASE_URL"))
 }
@@ -1,172 +1,245 @@
 package middlewares
 
 import (
-	"log"
-	"context"
 	"errors"
 	"net/http"
+	"strconv"
+	"time"
 	"rest-api/models"
 	"rest-api/components"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func UserAuthMiddlwareHandler(coll *mongo.Collection) gin.HandlerFunc{
+/*
+Single place where requests are authenticated and authorized
+Permissions required by each route are declared in setupRouter
+*/
+type Authorizer struct{
+	userColl *mongo.Collection
+	sessionColl *mongo.Collection
+	apiKeyColl *mongo.Collection
+	auditColl *mongo.Collection
+	revocations components.RevocationStore
+}
+
+func NewAuthorizer(userColl, sessionColl, apiKeyColl, auditColl *mongo.Collection, revocations components.RevocationStore) *Authorizer{
+	return &Authorizer{
+		userColl: userColl,
+		sessionColl: sessionColl,
+		apiKeyColl: apiKeyColl,
+		auditColl: auditColl,
+		revocations: revocations,
+	}
+}
+
+/*
+Authenticates the request and checks that the caller has every given permission
+Places caller's identity on the context for the next handlers
+*/
+func (auth *Authorizer) Require(permissions ...components.Permission) gin.HandlerFunc{
 	return func(c *gin.Context){
 
-		// Retrieving JWT token from request
-		tokenString, err := components.GetBearerToken(c)
-		if err != nil{
-			c.JSON(404, gin.H{"message":err.Error()})
+		// Verifying who is making the request
+		identity, err := auth.authenticate(c)
+		var restricted *models.AccountRestrictedError
+		if errors.As(err, &restricted){
+			c.JSON(http.StatusForbidden, components.AccountRestrictedResponse(restricted))
 			c.Abort()
 			return
 		}
-
-		// Retrieving JWT Secret for verifying JWT signature
-		secret, err := components.GetJWTSecret()
 		if err != nil{
-			c.JSON(404, gin.H{"message":err.Error()})
+			c.JSON(http.StatusUnauthorized, gin.H{"message":err.Error()})
 			c.Abort()
-			return 
+			return
 		}
 
-		// Parsing the JWT token retreiving from request
-		token, err:= jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok{
-				return "", errors.New("unable to parse token")
-			}
-			return secret, nil
-		})
-		if err != nil{
-			c.JSON(404, gin.H{"message":err.Error()})
-			c.Abort()
-			return
+		c.Set(components.IDENTITYKEY, identity)
+		c.Set(components.USERIDKEY, identity.UserId)
+		c.Set(components.SESSIONIDKEY, identity.SessionId)
+
+		// Impersonated requests are flagged and every one of them is recorded
+		if identity.ActorId != ""{
+			c.Header("X-Impersonated-By", identity.ActorId)
+			defer auth.recordImpersonatedRequest(c, identity)
 		}
 
-		// Checking Validity of parsed JWT Token
-		if claims, ok := token.Claims.(jwt.MapClaims); token.Valid && ok{
-			email := claims["email"]
-			pass := claims["password"]
-
-			// Retrieving user with credentials in JWT token
-			filter := bson.M{"email": email}
-			result := coll.FindOne(context.TODO(), filter)
-			var user models.User
-			err := result.Decode(&user)
-			if err!=nil{
-				c.JSON(http.StatusUnauthorized, gin.H{"message":"user info not in database, need to sign up again"})
+		// Verifying that the caller is allowed to make the request
+		for _, permission := range permissions{
+			if !identity.Can(permission){
+				message := "missing permission " + string(permission)
+				if identity.ActorId != ""{
+					message += ", not allowed while impersonating"
+				}else if !identity.Verified{
+					message += ", verify your email first"
+				}
+				c.JSON(http.StatusForbidden, gin.H{"message":message})
 				c.Abort()
 				return
 			}
+		}
 
-			// Verifying whether JWT Token retrieved was the latest assigned one
-			if user.LastToken != tokenString{
-				c.JSON(http.StatusUnauthorized, gin.H{"message":"use newly assigned token"})
-				c.Abort()
-				return
-			}
+		c.Next()
+	}
+}
 
-			// Verifying credentials of JWT token with credentials saved in db
-			// JWT contains hashed password and db also contains hashed password so simple == will do work
-			if user.Email != email || user.Password != pass{
-				log.Println("Expected email", user.Email, " and got from token email", email)
-				log.Println("Expected password", user.Password, " and got from token email", pass)
-				c.JSON(http.StatusUnauthorized, gin.H{"message":"credentials not valid due to incorrect password"})
-				c.Abort()
-				return
-			}
-			c.Set(components.USERIDKEY, user.ID)
+/*
+Lets requests without credentials through anonymously, for pages anyone can see
+Requests presenting credentials are checked like Require, a bad token isn't ignored
+*/
+func (auth *Authorizer) Optional(permissions ...components.Permission) gin.HandlerFunc{
+	require := auth.Require(permissions...)
+	return func(c *gin.Context){
+		if components.GetApiKey(c) == "" && c.Request.Header.Get("Authorization") == ""{
 			c.Next()
 			return
 		}
-		c.JSON(http.StatusUnauthorized, gin.H{"message":"Invalid jwt token"})
-		c.Abort()
+		require(c)
 	}
 }
 
+func (auth *Authorizer) authenticate(c *gin.Context)(*components.Identity, error){
 
+	// Requests of bots and integrations
+	if apiKey := components.GetApiKey(c); apiKey != ""{
+		return auth.authenticateApiKey(apiKey)
+	}
 
-func AdminAuthMiddlwareHandler(coll *mongo.Collection) gin.HandlerFunc{
-	return func(c *gin.Context){
+	// Retrieving JWT token from request
+	tokenString, err := components.GetBearerToken(c)
+	if err != nil{
+		return nil, err
+	}
 
-		// Retrieving token from request
-		tokenString, err := components.GetBearerToken(c)
-		if err != nil{
-			c.JSON(404, gin.H{"message":err.Error()})
-			c.Abort()
-			return
-		}
+	// Parsing the JWT token retreiving from request and verifying its claims
+	claims, err := components.ParseAccessToken(tokenString)
+	if err != nil{
+		return nil, err
+	}
 
-		// Retrieving JWT Secret for verifying JWT signature
-		secret, err := components.GetJWTSecret()
-		if err != nil{
-			c.JSON(404, gin.H{"message":err.Error()})
-			c.Abort()
-			return 
-		}
+	// Signed out tokens are rejected before they expire
+	revoked, err := auth.revocations.IsRevoked(claims.ID)
+	if err != nil{
+		return nil, err
+	}
+	if revoked{
+		return nil, errors.New("token has been revoked, sign in again")
+	}
 
-		// Parsing token string retrieving from request and verifying signature with JWT secret 
-		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok{
-				return "", errors.New("unable to parse token")
-			}
-			return secret, nil
-		})
-		if err != nil{
-			c.JSON(404, gin.H{"message":err.Error()})
-			c.Abort()
-			return
-		}
+	// Admin acting as the user
+	if claims.Actor != nil{
+		return auth.authenticateImpersonation(claims)
+	}
 
-		// Checking whether JWT token is valid or not
-		if claims, ok := token.Claims.(jwt.MapClaims); token.Valid && ok{
-			admin := claims["admin"].(bool)
-			email := claims["email"].(string)
-			pass := claims["password"].(string)
-
-			// Retrieving user from db to validate credentials of JWT token claims
-			var user models.User
-			filter := bson.M{"email": email}
-			result := coll.FindOne(context.TODO(), filter)
-			err := result.Decode(&user)
-			if err!=nil{
-				c.JSON(http.StatusUnauthorized, gin.H{"message":"user info not in database, need to sign up again"})
-				c.Abort()
-				return
-			}
+	// Retrieving user identified by subject of JWT token
+	user, err := models.GetUserById(claims.Subject, auth.userColl)
+	if err != nil{
+		return nil, errors.New("user info not in database, need to sign up again")
+	}
 
-			// Verifying whether JWT Token retrieved was the latest assigned one
-			if user.LastToken != tokenString{
-				c.JSON(http.StatusUnauthorized, gin.H{"message":"use newly issued token"})
-				c.Abort()
-				return
-			}
+	// Suspended and banned users can't use their account
+	if err := user.CheckStanding(time.Now()); err != nil{
+		return nil, err
+	}
 
-			// Validating credentials of JWT token with credentails in db
-			if user.Email != email || user.Password != pass{
-				c.JSON(http.StatusUnauthorized, gin.H{"message":"credentials not valid due to incorrect password"})
-				c.Abort()
-				return
-			}
+	// Verifying whether session of the JWT Token is still active
+	session, err := models.GetActiveSession(claims.SessionId, user.ID, auth.sessionColl)
+	if err != nil{
+		return nil, err
+	}
 
-			// Checking whether the provided user is admin or not
-			if !admin || !user.IsAdmin{
-				c.JSON(http.StatusUnauthorized, gin.H{"message":"Not admin user"})
-				c.Abort()
-				return
-			}else{
+	// Tokens issued before password change are not accepted
+	if user.TokenIssuedBeforePasswordChange(claims.IssuedAt.Time){
+		return nil, errors.New("password changed, sign in again")
+	}
+
+	models.TouchSession(session, c.ClientIP(), auth.sessionColl)
+
+	// Role is always taken from db, never from the token
+	return components.NewIdentity(user.ID, session.ID, user.GetRole(), user.Verified), nil
+}
 
-				// User is admin with correct credentials
-				// Let the user move to the next handler
-				c.Set(components.USERIDKEY, user.ID)
-				c.Next()
-				return
-			}
-		}
 
-		c.JSON(http.StatusUnauthorized, gin.H{"message":"Invalid jwt token"})
-		c.Abort()
+func (auth *Authorizer) authenticateApiKey(key string)(*components.Identity, error){
+
+	// Finding active api key
+	apiKey, err := models.GetApiKeyByHash(components.HashToken(key), auth.apiKeyColl)
+	if err != nil{
+		return nil, err
+	}
+
+	// Retrieving owner of the api key
+	user, err := models.GetUserById(apiKey.UserId, auth.userColl)
+	if err != nil{
+		return nil, errors.New("owner of api key no longer exists")
+	}
+	if err := user.CheckStanding(time.Now()); err != nil{
+		return nil, err
+	}
+
+	// Deletion is only cancelled by the user through /user/cancelDeletion, integrations can't keep the account going meanwhile
+	if user.IsPendingDeletion(){
+		return nil, errors.New("account is scheduled for deletion, sign in and cancel it through /user/cancelDeletion")
+	}
+
+	models.TouchApiKey(apiKey, auth.apiKeyColl)
+
+	return components.NewApiKeyIdentity(user.ID, apiKey.ID, user.GetRole(), user.Verified, apiKey.Scopes), nil
+}
+
+
+/*
+Impersonation token is only valid while the admin who minted it
+is still allowed to impersonate and their session is active,
+and while the impersonated user could still be impersonated
+*/
+func (auth *Authorizer) authenticateImpersonation(claims *components.AccessClaims)(*components.Identity, error){
+
+	// Verifying the impersonating admin
+	actor, err := models.GetUserById(claims.Actor.Subject, auth.userColl)
+	if err != nil{
+		return nil, errors.New("impersonating admin no longer exists")
+	}
+	if actor.CheckStanding(time.Now()) != nil || !components.NewIdentity(actor.ID, "", actor.GetRole(), actor.Verified).Can(components.PERMUSERIMPERSONATE){
+		return nil, errors.New("impersonating admin is no longer allowed to impersonate")
+	}
+	session, err := models.GetActiveSession(claims.SessionId, actor.ID, auth.sessionColl)
+	if err != nil{
+		return nil, err
+	}
+	if actor.TokenIssuedBeforePasswordChange(claims.IssuedAt.Time){
+		return nil, errors.New("password changed, sign in again")
+	}
+
+	// Retrieving the impersonated user
+	user, err := models.GetUserById(claims.Subject, auth.userColl)
+	if err != nil{
+		return nil, errors.New("impersonated user no longer exists")
+	}
+	if err := user.CheckStanding(time.Now()); err != nil{
+		return nil, err
+	}
+
+	// User may have been promoted or scheduled for deletion since the token was minted
+	if user.GetRole() == models.ROLEADMIN{
+		return nil, errors.New("cannot impersonate an admin")
+	}
+	if user.IsPendingDeletion(){
+		return nil, errors.New("impersonated user is scheduled for deletion")
+	}
+
+	return components.NewImpersonationIdentity(user.ID, actor.ID, session.ID, user.GetRole(), user.Verified), nil
+}
+
+func (auth *Authorizer) recordImpersonatedRequest(c *gin.Context, identity *components.Identity){
+	outcome := models.OUTCOMESUCCESS
+	if c.Writer.Status() >= http.StatusBadRequest{
+		outcome = models.OUTCOMEFAILURE
+	}
+	details := map[string]string{
+		"method": c.Request.Method,
+		"path": c.Request.URL.Path,
+		"status": strconv.Itoa(c.Writer.Status()),
 	}
+	components.RecordAuditEvent(c, models.EVENTIMPERSONATEDREQUEST, outcome, identity.UserId, details, auth.auditColl)
 }
@@ -0,0 +1,312 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"rest-api/components"
+	"rest-api/models"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const testClientIP = "192.0.2.1"
+
+func userDocument(id primitive.ObjectID, role models.Role, verified bool, extra ...bson.E) bson.D{
+	return append(bson.D{
+		{Key: "_id", Value: id},
+		{Key: "username", Value: "user"},
+		{Key: "email", Value: "user@example.com"},
+		{Key: "role", Value: role},
+		{Key: "verified", Value: verified},
+	}, extra...)
+}
+
+// Session seen just now from the test client, so it isn't touched
+func sessionDocument(id primitive.ObjectID, userId string) bson.D{
+	return bson.D{
+		{Key: "_id", Value: id},
+		{Key: "userId", Value: userId},
+		{Key: "ip", Value: testClientIP},
+		{Key: "lastSeenOn", Value: time.Now()},
+		{Key: "expiresOn", Value: time.Now().Add(time.Hour)},
+		{Key: "revoked", Value: false},
+	}
+}
+
+func apiKeyDocument(userId string, scopes ...string) bson.D{
+	return bson.D{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "userId", Value: userId},
+		{Key: "keyHash", Value: "hash"},
+		{Key: "scopes", Value: scopes},
+		{Key: "lastUsedOn", Value: time.Now()},
+		{Key: "revoked", Value: false},
+	}
+}
+
+// Runs request through Require(@permissions) and returns the response status
+func serve(auth *Authorizer, setHeaders func(*http.Request), permissions ...components.Permission) int{
+	return serveThrough(auth.Require(permissions...), setHeaders)
+}
+
+func serveThrough(middleware gin.HandlerFunc, setHeaders func(*http.Request)) int{
+	router := gin.New()
+	router.GET("/", middleware, func(c *gin.Context){
+		c.Status(http.StatusOK)
+	})
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.RemoteAddr = testClientIP + ":1234"
+	setHeaders(request)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func TestRequire(t *testing.T){
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "secret")
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	userId := primitive.NewObjectID()
+	sessionId := primitive.NewObjectID()
+	token, err := components.GenerateJWTToken(userId.Hex(), sessionId.Hex())
+	if err != nil{
+		t.Fatal(err)
+	}
+	withToken := func(request *http.Request){
+		request.Header.Set("Authorization", "Bearer " + token)
+	}
+	withApiKey := func(request *http.Request){
+		request.Header.Set("X-API-Key", components.APIKEYPREFIX + "key")
+	}
+
+	tests := []struct{
+		name string
+		headers func(*http.Request)
+		responses []bson.D
+		permissions []components.Permission
+		status int
+	}{
+		{
+			name: "no credentials",
+			headers: func(*http.Request){},
+			permissions: []components.Permission{components.PERMPLLREAD},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "user with permission",
+			headers: withToken,
+			responses: []bson.D{userDocument(userId, models.ROLEUSER, true), sessionDocument(sessionId, userId.Hex())},
+			permissions: []components.Permission{components.PERMPLLREAD, components.PERMPLLWRITE},
+			status: http.StatusOK,
+		},
+		{
+			name: "user without admin permission",
+			headers: withToken,
+			responses: []bson.D{userDocument(userId, models.ROLEUSER, true), sessionDocument(sessionId, userId.Hex())},
+			permissions: []components.Permission{components.PERMPLLREAD, components.PERMUSERROLE},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "admin with admin permission",
+			headers: withToken,
+			responses: []bson.D{userDocument(userId, models.ROLEADMIN, true), sessionDocument(sessionId, userId.Hex())},
+			permissions: []components.Permission{components.PERMUSERROLE},
+			status: http.StatusOK,
+		},
+		{
+			name: "moderator without admin permission",
+			headers: withToken,
+			responses: []bson.D{userDocument(userId, models.ROLEMODERATOR, true), sessionDocument(sessionId, userId.Hex())},
+			permissions: []components.Permission{components.PERMUSERMANAGE},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "unverified user writing",
+			headers: withToken,
+			responses: []bson.D{userDocument(userId, models.ROLEUSER, false), sessionDocument(sessionId, userId.Hex())},
+			permissions: []components.Permission{components.PERMPLLWRITE},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "banned user",
+			headers: withToken,
+			responses: []bson.D{userDocument(userId, models.ROLEUSER, true, bson.E{Key: "banned", Value: true})},
+			permissions: []components.Permission{components.PERMPLLREAD},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "revoked session",
+			headers: withToken,
+			responses: []bson.D{userDocument(userId, models.ROLEUSER, true), nil},
+			permissions: []components.Permission{components.PERMPLLREAD},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "api key within scope",
+			headers: withApiKey,
+			responses: []bson.D{apiKeyDocument(userId.Hex(), string(components.PERMPLLREAD)), userDocument(userId, models.ROLEUSER, true)},
+			permissions: []components.Permission{components.PERMPLLREAD},
+			status: http.StatusOK,
+		},
+		{
+			name: "api key outside scope",
+			headers: withApiKey,
+			responses: []bson.D{apiKeyDocument(userId.Hex(), string(components.PERMPLLREAD)), userDocument(userId, models.ROLEUSER, true)},
+			permissions: []components.Permission{components.PERMPLLWRITE},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "api key scope the owner lost",
+			headers: withApiKey,
+			responses: []bson.D{apiKeyDocument(userId.Hex(), string(components.PERMPLLWRITE)), userDocument(userId, models.ROLEUSER, false)},
+			permissions: []components.Permission{components.PERMPLLWRITE},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "api key of account pending deletion",
+			headers: withApiKey,
+			responses: []bson.D{apiKeyDocument(userId.Hex(), string(components.PERMPLLREAD)), userDocument(userId, models.ROLEUSER, true, bson.E{Key: "deletionScheduledOn", Value: time.Now().Add(time.Hour)})},
+			permissions: []components.Permission{components.PERMPLLREAD},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "api key managing account",
+			headers: withApiKey,
+			responses: []bson.D{apiKeyDocument(userId.Hex(), string(components.PERMPLLREAD)), userDocument(userId, models.ROLEUSER, true)},
+			permissions: []components.Permission{components.PERMACCOUNTMANAGE},
+			status: http.StatusForbidden,
+		},
+	}
+
+	for _, test := range tests{
+		mt.Run(test.name, func(mt *mtest.T){
+			// Each document answers one lookup, nil finds nothing
+			for _, document := range test.responses{
+				if document == nil{
+					mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.coll", mtest.FirstBatch))
+					continue
+				}
+				mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.coll", mtest.FirstBatch, document))
+			}
+			auth := NewAuthorizer(mt.DB.Collection("Users"), mt.DB.Collection("Sessions"), mt.DB.Collection("ApiKeys"), mt.DB.Collection("AuditEvents"), components.NewMemoryRevocationStore())
+			if status := serve(auth, test.headers, test.permissions...); status != test.status{
+				mt.Fatalf("expected status %d, got %d", test.status, status)
+			}
+		})
+	}
+
+	mt.Run("signed out token", func(mt *mtest.T){
+		claims, err := components.ParseAccessToken(token)
+		if err != nil{
+			mt.Fatal(err)
+		}
+		revocations := components.NewMemoryRevocationStore()
+		revocations.Revoke(claims.ID, claims.ExpiresAt.Time)
+		auth := NewAuthorizer(mt.DB.Collection("Users"), mt.DB.Collection("Sessions"), mt.DB.Collection("ApiKeys"), mt.DB.Collection("AuditEvents"), revocations)
+		if status := serve(auth, withToken, components.PERMPLLREAD); status != http.StatusUnauthorized{
+			mt.Fatalf("expected status %d, got %d", http.StatusUnauthorized, status)
+		}
+	})
+}
+
+func TestOptional(t *testing.T){
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "secret")
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	userId := primitive.NewObjectID()
+	sessionId := primitive.NewObjectID()
+	token, err := components.GenerateJWTToken(userId.Hex(), sessionId.Hex())
+	if err != nil{
+		t.Fatal(err)
+	}
+
+	mt.Run("anonymous request", func(mt *mtest.T){
+		auth := NewAuthorizer(mt.DB.Collection("Users"), mt.DB.Collection("Sessions"), mt.DB.Collection("ApiKeys"), mt.DB.Collection("AuditEvents"), components.NewMemoryRevocationStore())
+		if status := serveThrough(auth.Optional(components.PERMPLLREAD), func(*http.Request){}); status != http.StatusOK{
+			mt.Fatalf("expected status %d, got %d", http.StatusOK, status)
+		}
+		if count := len(mt.GetAllStartedEvents()); count != 0{
+			mt.Fatalf("expected no lookups, got %d", count)
+		}
+	})
+
+	mt.Run("signed in request", func(mt *mtest.T){
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch, userDocument(userId, models.ROLEUSER, true)),
+			mtest.CreateCursorResponse(0, "db.Sessions", mtest.FirstBatch, sessionDocument(sessionId, userId.Hex())),
+		)
+		auth := NewAuthorizer(mt.DB.Collection("Users"), mt.DB.Collection("Sessions"), mt.DB.Collection("ApiKeys"), mt.DB.Collection("AuditEvents"), components.NewMemoryRevocationStore())
+		status := serveThrough(auth.Optional(components.PERMPLLREAD), func(request *http.Request){
+			request.Header.Set("Authorization", "Bearer " + token)
+		})
+		if status != http.StatusOK{
+			mt.Fatalf("expected status %d, got %d", http.StatusOK, status)
+		}
+	})
+
+	mt.Run("invalid token", func(mt *mtest.T){
+		auth := NewAuthorizer(mt.DB.Collection("Users"), mt.DB.Collection("Sessions"), mt.DB.Collection("ApiKeys"), mt.DB.Collection("AuditEvents"), components.NewMemoryRevocationStore())
+		status := serveThrough(auth.Optional(components.PERMPLLREAD), func(request *http.Request){
+			request.Header.Set("Authorization", "Bearer invalid")
+		})
+		if status != http.StatusUnauthorized{
+			mt.Fatalf("expected status %d, got %d", http.StatusUnauthorized, status)
+		}
+	})
+}
+
+func TestImpersonation(t *testing.T){
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "secret")
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	actorId := primitive.NewObjectID()
+	sessionId := primitive.NewObjectID()
+	userId := primitive.NewObjectID()
+	token, err := components.GenerateImpersonationToken(userId.Hex(), actorId.Hex(), sessionId.Hex(), time.Minute)
+	if err != nil{
+		t.Fatal(err)
+	}
+	withToken := func(request *http.Request){
+		request.Header.Set("Authorization", "Bearer " + token)
+	}
+
+	// Target may have changed since the admin minted the token
+	tests := []struct{
+		name string
+		target bson.D
+		status int
+	}{
+		{"regular user", userDocument(userId, models.ROLEUSER, true), http.StatusOK},
+		{"user promoted to admin", userDocument(userId, models.ROLEADMIN, true), http.StatusUnauthorized},
+		{"user banned", userDocument(userId, models.ROLEUSER, true, bson.E{Key: "banned", Value: true}), http.StatusForbidden},
+		{"user scheduled for deletion", userDocument(userId, models.ROLEUSER, true, bson.E{Key: "deletionScheduledOn", Value: time.Now().Add(time.Hour)}), http.StatusUnauthorized},
+	}
+	for _, test := range tests{
+		mt.Run(test.name, func(mt *mtest.T){
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch, userDocument(actorId, models.ROLEADMIN, true)),
+				mtest.CreateCursorResponse(0, "db.Sessions", mtest.FirstBatch, sessionDocument(sessionId, actorId.Hex())),
+				mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch, test.target),
+				mtest.CreateSuccessResponse(),
+			)
+			auth := NewAuthorizer(mt.DB.Collection("Users"), mt.DB.Collection("Sessions"), mt.DB.Collection("ApiKeys"), mt.DB.Collection("AuditEvents"), components.NewMemoryRevocationStore())
+			if status := serve(auth, withToken, components.PERMPLLREAD); status != test.status{
+				mt.Fatalf("expected status %d, got %d", test.status, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Users stay around until the grace period ends, so they can change their mind
+func (user *User) IsPendingDeletion() bool{
+	return !user.DeletionScheduledOn.IsZero()
+}
+
+func ScheduleUserDeletion(userId string, scheduledOn time.Time, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{"_id": id}
+	update := bson.M{
+		"$set": bson.M{
+			"deletionRequestedOn": time.Now(),
+			"deletionScheduledOn": scheduledOn,
+		},
+	}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+/*
+Only matches users whose deletion is still pending
+*/
+func CancelUserDeletion(userId string, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{"_id": id, "deletionScheduledOn": bson.M{"$exists": true}}
+	update := bson.M{
+		"$unset": bson.M{
+			"deletionRequestedOn": "",
+			"deletionScheduledOn": "",
+		},
+	}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+// Returns ids of users whose grace period has ended by @now
+func GetUserIdsDueForDeletion(now time.Time, coll *mongo.Collection)([]string, error){
+	filter := bson.M{"deletionScheduledOn": bson.M{"$lte": now}}
+	cursor, err := coll.Find(context.TODO(), filter)
+	if err != nil{
+		return nil, err
+	}
+	var users []User
+	if err := cursor.All(context.TODO(), &users); err != nil{
+		return nil, err
+	}
+	userIds := make([]string, 0, len(users))
+	for _, user := range users{
+		userIds = append(userIds, user.ID)
+	}
+	return userIds, nil
+}
+
+/*
+Deletes lessons of the user along with every comment on them
+*/
+func DeleteUserPlls(userId string, pllColl, commentColl *mongo.Collection) error{
+	cursor, err := pllColl.Find(context.TODO(), bson.M{"userId": userId})
+	if err != nil{
+		return err
+	}
+	var plls []PersonalLifeLesson
+	if err := cursor.All(context.TODO(), &plls); err != nil{
+		return err
+	}
+	pllIds := make([]string, 0, len(plls))
+	for _, pll := range plls{
+		pllIds = append(pllIds, pll.ID)
+	}
+	if _, err := commentColl.DeleteMany(context.TODO(), bson.M{"pllId": bson.M{"$in": pllIds}}); err != nil{
+		return err
+	}
+	_, err = pllColl.DeleteMany(context.TODO(), bson.M{"userId": userId})
+	return err
+}
+
+/*
+Deletes comments of the user and removes them from the lessons they were made on
+*/
+func DeleteUserComments(userId string, pllColl, commentColl *mongo.Collection) error{
+	cursor, err := commentColl.Find(context.TODO(), bson.M{"userId": userId})
+	if err != nil{
+		return err
+	}
+	var comments []Comment
+	if err := cursor.All(context.TODO(), &comments); err != nil{
+		return err
+	}
+	commentIds := make([]string, 0, len(comments))
+	for _, comment := range comments{
+		commentIds = append(commentIds, comment.ID)
+	}
+	filter := bson.M{"comments": bson.M{"$in": commentIds}}
+	update := bson.M{"$pull": bson.M{"comments": bson.M{"$in": commentIds}}}
+	if _, err := pllColl.UpdateMany(context.TODO(), filter, update); err != nil{
+		return err
+	}
+	_, err = commentColl.DeleteMany(context.TODO(), bson.M{"userId": userId})
+	return err
+}
+
+// Removes the user from likes of every lesson
+func PullUserLikes(userId string, pllColl *mongo.Collection) error{
+	_, err := pllColl.UpdateMany(context.TODO(), bson.M{"likes": userId}, bson.M{"$pull": bson.M{"likes": userId}})
+	return err
+}
+
+/*
+Deletes follow edges, blocks, mutes and category subscriptions of the user in either direction
+*/
+func DeleteUserGraph(userId string, followColl, relationColl, subscriptionColl *mongo.Collection) error{
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return err
+	}
+	if _, err := followColl.DeleteMany(context.TODO(), bson.M{"$or": bson.A{bson.M{"followerId": id}, bson.M{"followeeId": id}}}); err != nil{
+		return err
+	}
+	if _, err := relationColl.DeleteMany(context.TODO(), bson.M{"$or": bson.A{bson.M{"userId": id}, bson.M{"targetId": id}}}); err != nil{
+		return err
+	}
+	_, err = subscriptionColl.DeleteMany(context.TODO(), bson.M{"userId": id})
+	return err
+}
+
+/*
+Deletes every document of @colls referencing the user through userId,
+e.g. sessions, refresh tokens and api keys
+*/
+func DeleteUserDocuments(userId string, colls ...*mongo.Collection) error{
+	for _, coll := range colls{
+		if _, err := coll.DeleteMany(context.TODO(), bson.M{"userId": userId}); err != nil{
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Request body from admin when suspending a user
+type SuspensionRequest struct{
+	UserId string `json:"userId"`
+	Until time.Time `json:"until"`
+	Reason string `json:"reason"`
+}
+
+// Request body from admin when banning a user
+type BanRequest struct{
+	UserId string `json:"userId"`
+	Reason string `json:"reason"`
+	HideContent bool `json:"hideContent"`
+}
+
+/*
+Returned when a suspended or banned user tries to use their account
+*/
+type AccountRestrictedError struct{
+	Banned bool
+	Until time.Time
+	Reason string
+}
+
+func (err *AccountRestrictedError) Error() string{
+	if err.Banned{
+		return "account is banned"
+	}
+	return "account is suspended until " + err.Until.UTC().Format(time.RFC3339)
+}
+
+/*
+Returns AccountRestrictedError when the user is banned or suspended at @now
+Suspensions end on their own once the end time has passed
+*/
+func (user *User) CheckStanding(now time.Time) error{
+	if user.Banned{
+		return &AccountRestrictedError{Banned: true, Reason: user.RestrictionReason}
+	}
+	if now.Before(user.SuspendedUntil){
+		return &AccountRestrictedError{Until: user.SuspendedUntil, Reason: user.RestrictionReason}
+	}
+	return nil
+}
+
+func SuspendUser(request *SuspensionRequest, restrictedBy string, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(request.UserId)
+	if err != nil{
+		return nil, err
+	}
+	// Admins can't be restricted, even if promoted meanwhile
+	filter := bson.M{"_id": id, "role": bson.M{"$ne": ROLEADMIN}}
+	update := bson.M{
+		"$set": bson.M{
+			"suspendedUntil": request.Until,
+			"restrictionReason": request.Reason,
+			"restrictedBy": restrictedBy,
+			"restrictedOn": time.Now(),
+		},
+	}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+func BanUser(request *BanRequest, restrictedBy string, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(request.UserId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{"_id": id, "role": bson.M{"$ne": ROLEADMIN}}
+	update := bson.M{
+		"$set": bson.M{
+			"banned": true,
+			"contentHidden": request.HideContent,
+			"restrictionReason": request.Reason,
+			"restrictedBy": restrictedBy,
+			"restrictedOn": time.Now(),
+		},
+		"$unset": bson.M{"suspendedUntil": ""},
+	}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+/*
+Lifts suspension or ban of the user, hidden content becomes visible again
+*/
+func ReinstateUser(userId string, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{"_id": id}
+	update := bson.M{
+		"$unset": bson.M{
+			"banned": "",
+			"contentHidden": "",
+			"suspendedUntil": "",
+			"restrictionReason": "",
+			"restrictedBy": "",
+			"restrictedOn": "",
+		},
+	}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+/*
+Whether lessons and comments of the user are hidden from listings,
+either by a ban or while their account is waiting to be deleted
+*/
+func (user *User) IsHidden() bool{
+	return (user.Banned && user.ContentHidden) || user.IsPendingDeletion()
+}
+
+/*
+Ids of hidden users
+*/
+func GetHiddenUserIds(coll *mongo.Collection)([]string, error){
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"banned": true, "contentHidden": true},
+			bson.M{"deletionScheduledOn": bson.M{"$exists": true}},
+		},
+	}
+	cursor, err := coll.Find(context.TODO(), filter)
+	if err != nil{
+		return nil, err
+	}
+	var users []User
+	if err := cursor.All(context.TODO(), &users); err != nil{
+		return nil, err
+	}
+	userIds := make([]string, 0, len(users))
+	for _, user := range users{
+		userIds = append(userIds, user.ID)
+	}
+	return userIds, nil
+}
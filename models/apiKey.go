@@ -0,0 +1,127 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Last used time is updated at most once in this duration
+const APIKEYTOUCHINTERVAL = time.Minute
+
+// Request body from user when creating api key
+type ApiKeyRequest struct{
+	Name string `json:"name" bson:"name"`
+	Scopes []string `json:"scopes" bson:"scopes"`
+}
+
+// Actual data that will be added to the db, only the hash of the key is stored
+type ApiKeyIntermediate struct{
+	UserId string `json:"userId" bson:"userId"`
+	Name string `json:"name" bson:"name"`
+	Prefix string `json:"prefix" bson:"prefix"`
+	KeyHash string `json:"-" bson:"keyHash"`
+	Scopes []string `json:"scopes" bson:"scopes"`
+	CreatedOn time.Time `json:"createdOn" bson:"createdOn"`
+	Revoked bool `json:"revoked" bson:"revoked"`
+}
+
+// Full data that is stored in db
+type ApiKey struct{
+	ID string `json:"_id" bson:"_id"`
+	UserId string `json:"userId" bson:"userId"`
+	Name string `json:"name" bson:"name"`
+	Prefix string `json:"prefix" bson:"prefix"`
+	KeyHash string `json:"-" bson:"keyHash"`
+	Scopes []string `json:"scopes" bson:"scopes"`
+	CreatedOn time.Time `json:"createdOn" bson:"createdOn"`
+	LastUsedOn time.Time `json:"lastUsedOn,omitempty" bson:"lastUsedOn,omitempty"`
+	Revoked bool `json:"revoked" bson:"revoked"`
+}
+
+func (request *ApiKeyRequest) ToApiKeyIntermediate(userId, prefix, keyHash string) *ApiKeyIntermediate{
+	return &ApiKeyIntermediate{
+		UserId: userId,
+		Name: request.Name,
+		Prefix: prefix,
+		KeyHash: keyHash,
+		Scopes: request.Scopes,
+		CreatedOn: time.Now(),
+	}
+}
+
+func (apiKey *ApiKeyIntermediate) AddApiKey(coll *mongo.Collection)(*mongo.InsertOneResult, error){
+	return coll.InsertOne(context.TODO(), apiKey)
+}
+
+/*
+Returns active api key with @keyHash
+*/
+func GetApiKeyByHash(keyHash string, coll *mongo.Collection)(*ApiKey, error){
+	filter := bson.M{"keyHash": keyHash, "revoked": false}
+	var apiKey ApiKey
+	if err := coll.FindOne(context.TODO(), filter).Decode(&apiKey); err != nil{
+		return nil, errors.New("invalid api key")
+	}
+	return &apiKey, nil
+}
+
+/*
+Returns active api keys of @userId, latest first
+*/
+func GetApiKeys(userId string, coll *mongo.Collection)([]ApiKey, error){
+	apiKeys := make([]ApiKey, 0)
+
+	opts := options.Find().SetSort(bson.M{"_id": -1})
+	filter := bson.M{"userId": userId, "revoked": false}
+	cursor, err := coll.Find(context.TODO(), filter, opts)
+	if err != nil{
+		return apiKeys, err
+	}
+	err = cursor.All(context.TODO(), &apiKeys)
+	return apiKeys, err
+}
+
+func TouchApiKey(apiKey *ApiKey, coll *mongo.Collection) error{
+	if time.Since(apiKey.LastUsedOn) < APIKEYTOUCHINTERVAL{
+		return nil
+	}
+	id, err := primitive.ObjectIDFromHex(apiKey.ID)
+	if err != nil{
+		return err
+	}
+	_, err = coll.UpdateOne(context.TODO(), bson.M{"_id": id}, bson.M{"$set": bson.M{"lastUsedOn": time.Now()}})
+	return err
+}
+
+func RevokeApiKey(apiKeyId, userId string, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(apiKeyId)
+	if err != nil{
+		return nil, errors.New("invalid api key id")
+	}
+	filter := bson.M{"_id": id, "userId": userId}
+	update := bson.M{"$set": bson.M{"revoked": true}}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+// Revokes every api key of the user, e.g. once their password changed
+func RevokeUserApiKeys(userId string, coll *mongo.Collection) error{
+	filter := bson.M{"userId": userId, "revoked": false}
+	update := bson.M{"$set": bson.M{"revoked": true}}
+	_, err := coll.UpdateMany(context.TODO(), filter, update)
+	return err
+}
+
+func EnsureApiKeyIndexes(coll *mongo.Collection) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"keyHash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"userId": 1}},
+	}
+	_, err := coll.Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Security relevant events of an account
+type AuditEventType string
+
+const (
+	EVENTSIGNUP AuditEventType = "signUp"
+	EVENTLOGIN AuditEventType = "login"
+	EVENTLOGOUT AuditEventType = "logout"
+	EVENTTOKENREFRESH AuditEventType = "tokenRefresh"
+	EVENTPASSWORDCHANGE AuditEventType = "passwordChange"
+	EVENTEMAILCHANGE AuditEventType = "emailChange"
+	EVENTROLECHANGE AuditEventType = "roleChange"
+	EVENTACCOUNTRESTRICTION AuditEventType = "accountRestriction"
+	EVENTACCOUNTDELETION AuditEventType = "accountDeletion"
+	EVENTIMPERSONATION AuditEventType = "impersonation"
+	EVENTIMPERSONATEDREQUEST AuditEventType = "impersonatedRequest"
+)
+
+type AuditOutcome string
+
+const (
+	OUTCOMESUCCESS AuditOutcome = "success"
+	OUTCOMEFAILURE AuditOutcome = "failure"
+)
+
+// Default and maximum number of events returned by a query
+const (
+	AUDITQUERYLIMIT int64 = 100
+	AUDITQUERYMAXLIMIT int64 = 1000
+)
+
+/*
+Actual data that will be added to the db
+Events are only ever inserted, never updated or deleted
+*/
+type AuditEventIntermediate struct{
+	UserId string `json:"userId,omitempty" bson:"userId,omitempty"`
+	ActorId string `json:"actorId,omitempty" bson:"actorId,omitempty"`
+	Type AuditEventType `json:"type" bson:"type"`
+	Outcome AuditOutcome `json:"outcome" bson:"outcome"`
+	IP string `json:"ip" bson:"ip"`
+	UserAgent string `json:"userAgent" bson:"userAgent"`
+	Details map[string]string `json:"details,omitempty" bson:"details,omitempty"`
+	CreatedOn time.Time `json:"createdOn" bson:"createdOn"`
+}
+
+// Full data that is stored in db
+type AuditEvent struct{
+	ID string `json:"_id" bson:"_id"`
+	UserId string `json:"userId,omitempty" bson:"userId,omitempty"`
+	ActorId string `json:"actorId,omitempty" bson:"actorId,omitempty"`
+	Type AuditEventType `json:"type" bson:"type"`
+	Outcome AuditOutcome `json:"outcome" bson:"outcome"`
+	IP string `json:"ip" bson:"ip"`
+	UserAgent string `json:"userAgent" bson:"userAgent"`
+	Details map[string]string `json:"details,omitempty" bson:"details,omitempty"`
+	CreatedOn time.Time `json:"createdOn" bson:"createdOn"`
+}
+
+// Conditions of an audit log query, zero values match everything
+type AuditEventFilter struct{
+	UserId string
+	Type AuditEventType
+	From time.Time
+	To time.Time
+	Limit int64
+}
+
+func (event *AuditEventIntermediate) AddAuditEvent(coll *mongo.Collection)(*mongo.InsertOneResult, error){
+	return coll.InsertOne(context.TODO(), event)
+}
+
+/*
+Returns events matching @query, latest first
+*/
+func GetAuditEvents(query *AuditEventFilter, coll *mongo.Collection)([]AuditEvent, error){
+	events := make([]AuditEvent, 0)
+
+	filter := bson.M{}
+	if query.UserId != ""{
+		filter["userId"] = query.UserId
+	}
+	if query.Type != ""{
+		filter["type"] = query.Type
+	}
+	createdOn := bson.M{}
+	if !query.From.IsZero(){
+		createdOn["$gte"] = query.From
+	}
+	if !query.To.IsZero(){
+		createdOn["$lt"] = query.To
+	}
+	if len(createdOn) > 0{
+		filter["createdOn"] = createdOn
+	}
+
+	limit := query.Limit
+	if limit <= 0{
+		limit = AUDITQUERYLIMIT
+	}
+	if limit > AUDITQUERYMAXLIMIT{
+		limit = AUDITQUERYMAXLIMIT
+	}
+	opts := options.Find().SetSort(bson.M{"createdOn": -1}).SetLimit(limit)
+	cursor, err := coll.Find(context.TODO(), filter, opts)
+	if err != nil{
+		return events, err
+	}
+	err = cursor.All(context.TODO(), &events)
+	return events, err
+}
+
+func EnsureAuditEventIndexes(coll *mongo.Collection) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdOn", Value: -1}}},
+		{Keys: bson.D{{Key: "type", Value: 1}, {Key: "createdOn", Value: -1}}},
+		{Keys: bson.M{"createdOn": -1}},
+	}
+	_, err := coll.Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
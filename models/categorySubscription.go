@@ -0,0 +1,62 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Lessons of subscribed categories show up in the feed of the user
+// Category is referenced the same way as in lessons
+type CategorySubscription struct{
+	ID string `json:"_id" bson:"_id,omitempty"`
+	UserId primitive.ObjectID `json:"userId" bson:"userId"`
+	CategoryId string `json:"categoryId" bson:"categoryId"`
+	SubscribedOn time.Time `json:"subscribedOn" bson:"subscribedOn"`
+}
+
+func AddCategorySubscription(userId, categoryId string, coll *mongo.Collection) error{
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return err
+	}
+	filter := bson.M{"userId": id, "categoryId": categoryId}
+	update := bson.M{"$setOnInsert": bson.M{"subscribedOn": time.Now()}}
+	_, err = coll.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func RemoveCategorySubscription(userId, categoryId string, coll *mongo.Collection)(*mongo.DeleteResult, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return nil, err
+	}
+	return coll.DeleteOne(context.TODO(), bson.M{"userId": id, "categoryId": categoryId})
+}
+
+func GetCategorySubscriptions(userId string, coll *mongo.Collection)([]CategorySubscription, error){
+	subscriptions := make([]CategorySubscription, 0)
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return subscriptions, err
+	}
+	opts := options.Find().SetSort(bson.M{"subscribedOn": -1})
+	cursor, err := coll.Find(context.TODO(), bson.M{"userId": id}, opts)
+	if err != nil{
+		return subscriptions, err
+	}
+	err = cursor.All(context.TODO(), &subscriptions)
+	return subscriptions, err
+}
+
+func EnsureCategorySubscriptionIndexes(coll *mongo.Collection) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "categoryId", Value: 1}}, Options: options.Index().SetUnique(true)},
+	}
+	_, err := coll.Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
@@ -120,7 +120,8 @@ func DeleteComment(commentId string, pllColl, commentColl *mongo.Collection) err
 
 
 
-func GetComments(commentIds []string, coll *mongo.Collection) []Comment{
+// Comments of @hiddenUserIds (e.g. banned users) are left out
+func GetComments(commentIds, hiddenUserIds []string, coll *mongo.Collection) []Comment{
 	comments := make([]Comment, len(commentIds))
 	ids := make([]primitive.ObjectID, len(commentIds))
 
@@ -130,6 +131,9 @@ func GetComments(commentIds []string, coll *mongo.Collection) []Comment{
 	}
 	opts := options.Find().SetSort(bson.M{"_id":-1})
 	filter := bson.M{"_id": bson.M{"$in": ids}}
+	if len(hiddenUserIds) > 0{
+		filter["userId"] = bson.M{"$nin": hiddenUserIds}
+	}
 	result, _ := coll.Find(context.TODO(), filter, opts)
 	_ = result.All(context.TODO(), &comments)
 	return comments
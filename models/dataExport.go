@@ -0,0 +1,247 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DataExportStatus string
+
+const (
+	EXPORTPENDING DataExportStatus = "pending"
+	EXPORTREADY DataExportStatus = "ready"
+	EXPORTFAILED DataExportStatus = "failed"
+)
+
+// GridFS bucket holding the archives
+const DATAEXPORTARCHIVEBUCKET = "DataExportArchives"
+
+func NewDataExportArchives(db *mongo.Database)(*gridfs.Bucket, error){
+	return gridfs.NewBucket(db, options.GridFSBucket().SetName(DATAEXPORTARCHIVEBUCKET))
+}
+
+/*
+Archive of everything tied to a user, assembled in the background
+Actual data that will be added to the db, dropped by mongo once expired
+The archive itself is a GridFS file with the id of the export, so its size isn't limited
+*/
+type DataExportIntermediate struct{
+	UserId string `json:"userId" bson:"userId"`
+	Status DataExportStatus `json:"status" bson:"status"`
+	RequestedOn time.Time `json:"requestedOn" bson:"requestedOn"`
+	ExpiresOn time.Time `json:"expiresOn" bson:"expiresOn"`
+}
+
+// Full data that is stored in db
+type DataExport struct{
+	ID string `json:"_id" bson:"_id"`
+	UserId string `json:"userId" bson:"userId"`
+	Status DataExportStatus `json:"status" bson:"status"`
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+	RequestedOn time.Time `json:"requestedOn" bson:"requestedOn"`
+	CompletedOn time.Time `json:"completedOn,omitempty" bson:"completedOn,omitempty"`
+	ExpiresOn time.Time `json:"expiresOn" bson:"expiresOn"`
+	Size int64 `json:"size,omitempty" bson:"size,omitempty"`
+}
+
+func (export *DataExportIntermediate) AddDataExport(coll *mongo.Collection)(*mongo.InsertOneResult, error){
+	return coll.InsertOne(context.TODO(), export)
+}
+
+/*
+Saves the archive into @archives and marks the export ready
+Archive carries owner and expiry of the export, so it can be removed along with it
+*/
+func CompleteDataExport(exportId, userId string, archive []byte, expiresOn time.Time, coll *mongo.Collection, archives *gridfs.Bucket) error{
+	id, err := primitive.ObjectIDFromHex(exportId)
+	if err != nil{
+		return err
+	}
+	opts := options.GridFSUpload().SetMetadata(bson.M{"userId": userId, "expiresOn": expiresOn})
+	if err := archives.UploadFromStreamWithID(id, "data-export-" + exportId + ".zip", bytes.NewReader(archive), opts); err != nil{
+		return err
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status": EXPORTREADY,
+			"completedOn": time.Now(),
+			"size": len(archive),
+		},
+	}
+	_, err = coll.UpdateOne(context.TODO(), bson.M{"_id": id}, update)
+	return err
+}
+
+func FailDataExport(exportId, reason string, coll *mongo.Collection) error{
+	id, err := primitive.ObjectIDFromHex(exportId)
+	if err != nil{
+		return err
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status": EXPORTFAILED,
+			"completedOn": time.Now(),
+			"error": reason,
+		},
+	}
+	_, err = coll.UpdateOne(context.TODO(), bson.M{"_id": id}, update)
+	return err
+}
+
+func GetDataExport(exportId string, coll *mongo.Collection)(*DataExport, error){
+	id, err := primitive.ObjectIDFromHex(exportId)
+	if err != nil{
+		return nil, err
+	}
+	var export DataExport
+	if err := coll.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&export); err != nil{
+		return nil, err
+	}
+	return &export, nil
+}
+
+/*
+Exports still pending when assembling them can't have taken longer are marked failed,
+e.g. the server restarted while assembling them
+*/
+func FailStaleDataExports(requestedBefore time.Time, coll *mongo.Collection) error{
+	filter := bson.M{"status": EXPORTPENDING, "requestedOn": bson.M{"$lt": requestedBefore}}
+	update := bson.M{
+		"$set": bson.M{
+			"status": EXPORTFAILED,
+			"completedOn": time.Now(),
+			"error": "data export was interrupted",
+		},
+	}
+	_, err := coll.UpdateMany(context.TODO(), filter, update)
+	return err
+}
+
+// Stream of the archive, its length is in GetFile()
+func OpenDataExportArchive(exportId string, archives *gridfs.Bucket)(*gridfs.DownloadStream, error){
+	id, err := primitive.ObjectIDFromHex(exportId)
+	if err != nil{
+		return nil, err
+	}
+	return archives.OpenDownloadStream(id)
+}
+
+// Mongo doesn't expire GridFS files, so expired archives are removed by us
+func DeleteExpiredDataExportArchives(now time.Time, archives *gridfs.Bucket) error{
+	return deleteDataExportArchives(bson.M{"metadata.expiresOn": bson.M{"$lte": now}}, archives)
+}
+
+func DeleteUserDataExportArchives(userId string, archives *gridfs.Bucket) error{
+	return deleteDataExportArchives(bson.M{"metadata.userId": userId}, archives)
+}
+
+func deleteDataExportArchives(filter bson.M, archives *gridfs.Bucket) error{
+	cursor, err := archives.Find(filter)
+	if err != nil{
+		return err
+	}
+	var files []struct{
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(context.TODO(), &files); err != nil{
+		return err
+	}
+	for _, file := range files{
+		if err := archives.Delete(file.ID); err != nil && err != gridfs.ErrFileNotFound{
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns latest unexpired export of the user
+func GetLatestDataExport(userId string, coll *mongo.Collection)(*DataExport, error){
+	filter := bson.M{"userId": userId, "expiresOn": bson.M{"$gt": time.Now()}}
+	opts := options.FindOne().SetSort(bson.M{"requestedOn": -1})
+	var export DataExport
+	if err := coll.FindOne(context.TODO(), filter, opts).Decode(&export); err != nil{
+		return nil, err
+	}
+	return &export, nil
+}
+
+// Returns every lesson of the user, anonymous ones included
+func GetPllsOfUser(userId string, coll *mongo.Collection)([]PersonalLifeLesson, error){
+	return findPlls(bson.M{"userId": userId}, coll)
+}
+
+func GetPllsLikedByUser(userId string, coll *mongo.Collection)([]PersonalLifeLesson, error){
+	return findPlls(bson.M{"likes": userId}, coll)
+}
+
+func findPlls(filter bson.M, coll *mongo.Collection)([]PersonalLifeLesson, error){
+	plls := make([]PersonalLifeLesson, 0)
+	opts := options.Find().SetSort(bson.M{"_id": -1})
+	cursor, err := coll.Find(context.TODO(), filter, opts)
+	if err != nil{
+		return plls, err
+	}
+	err = cursor.All(context.TODO(), &plls)
+	return plls, err
+}
+
+func GetCommentsOfUser(userId string, coll *mongo.Collection)([]Comment, error){
+	comments := make([]Comment, 0)
+	opts := options.Find().SetSort(bson.M{"_id": -1})
+	cursor, err := coll.Find(context.TODO(), bson.M{"userId": userId}, opts)
+	if err != nil{
+		return comments, err
+	}
+	err = cursor.All(context.TODO(), &comments)
+	return comments, err
+}
+
+// Returns every session of the user, revoked ones included
+func GetAllUserSessions(userId string, coll *mongo.Collection)([]Session, error){
+	sessions := make([]Session, 0)
+	opts := options.Find().SetSort(bson.M{"createdOn": -1})
+	cursor, err := coll.Find(context.TODO(), bson.M{"userId": userId}, opts)
+	if err != nil{
+		return sessions, err
+	}
+	err = cursor.All(context.TODO(), &sessions)
+	return sessions, err
+}
+
+// Returns every audit event about the user without the query limit
+func GetAllUserAuditEvents(userId string, coll *mongo.Collection)([]AuditEvent, error){
+	events := make([]AuditEvent, 0)
+	opts := options.Find().SetSort(bson.M{"createdOn": -1})
+	cursor, err := coll.Find(context.TODO(), bson.M{"userId": userId}, opts)
+	if err != nil{
+		return events, err
+	}
+	err = cursor.All(context.TODO(), &events)
+	return events, err
+}
+
+func EnsureDataExportIndexes(coll *mongo.Collection) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"expiresOn": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "requestedOn", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "requestedOn", Value: 1}}},
+	}
+	_, err := coll.Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
+
+func EnsureDataExportArchiveIndexes(archives *gridfs.Bucket) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"metadata.expiresOn": 1}},
+		{Keys: bson.M{"metadata.userId": 1}},
+	}
+	_, err := archives.GetFilesCollection().Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
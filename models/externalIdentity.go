@@ -0,0 +1,65 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Account at an external identity provider linked to a user
+// Actual data that will be added to the db
+type ExternalIdentityIntermediate struct{
+	UserId string `json:"userId" bson:"userId"`
+	Provider string `json:"provider" bson:"provider"`
+	Subject string `json:"subject" bson:"subject"`
+	Email string `json:"email" bson:"email"`
+	LinkedOn time.Time `json:"linkedOn" bson:"linkedOn"`
+}
+
+// Full data that is stored in db
+type ExternalIdentity struct{
+	ID string `json:"_id" bson:"_id"`
+	UserId string `json:"userId" bson:"userId"`
+	Provider string `json:"provider" bson:"provider"`
+	Subject string `json:"subject" bson:"subject"`
+	Email string `json:"email" bson:"email"`
+	LinkedOn time.Time `json:"linkedOn" bson:"linkedOn"`
+}
+
+func NewExternalIdentityIntermediate(userId, provider, subject, email string) *ExternalIdentityIntermediate{
+	return &ExternalIdentityIntermediate{
+		UserId: userId,
+		Provider: provider,
+		Subject: subject,
+		Email: email,
+		LinkedOn: time.Now(),
+	}
+}
+
+func (identity *ExternalIdentityIntermediate) AddExternalIdentity(coll *mongo.Collection)(*mongo.InsertOneResult, error){
+	return coll.InsertOne(context.TODO(), identity)
+}
+
+/*
+Returns identity with @subject at @provider
+*/
+func GetExternalIdentity(provider, subject string, coll *mongo.Collection)(*ExternalIdentity, error){
+	filter := bson.M{"provider": provider, "subject": subject}
+	var identity ExternalIdentity
+	if err := coll.FindOne(context.TODO(), filter).Decode(&identity); err != nil{
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func EnsureExternalIdentityIndexes(coll *mongo.Collection) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"userId": 1}},
+	}
+	_, err := coll.Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
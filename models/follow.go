@@ -0,0 +1,205 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Edge of the social graph, follower sees lessons of followee
+// Users are referenced by the ObjectIDs of their documents
+type Follow struct{
+	ID string `json:"_id" bson:"_id,omitempty"`
+	FollowerId primitive.ObjectID `json:"followerId" bson:"followerId"`
+	FolloweeId primitive.ObjectID `json:"followeeId" bson:"followeeId"`
+	FollowedOn time.Time `json:"followedOn" bson:"followedOn"`
+}
+
+// Small public part of a user shown in lists
+type UserSummary struct{
+	ID string `json:"_id"`
+	Username string `json:"username"`
+	Handle string `json:"handle,omitempty"`
+	Photo string `json:"photo,omitempty"`
+}
+
+// Entry of followers and following lists
+type FollowListItem struct{
+	User UserSummary `json:"user"`
+	FollowedOn time.Time `json:"followedOn"`
+	IsFollowing bool `json:"isFollowing"`
+}
+
+func (user *User) ToUserSummary() UserSummary{
+	return UserSummary{
+		ID: user.ID,
+		Username: user.Username,
+		Handle: user.Handle,
+		Photo: user.Photo,
+	}
+}
+
+func toObjectIDs(userIds ...string)([]primitive.ObjectID, error){
+	ids := make([]primitive.ObjectID, 0, len(userIds))
+	for _, userId := range userIds{
+		id, err := primitive.ObjectIDFromHex(userId)
+		if err != nil{
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+/*
+Following someone already followed keeps the original edge
+*/
+func AddFollow(followerId, followeeId string, coll *mongo.Collection) error{
+	ids, err := toObjectIDs(followerId, followeeId)
+	if err != nil{
+		return err
+	}
+	filter := bson.M{"followerId": ids[0], "followeeId": ids[1]}
+	update := bson.M{"$setOnInsert": bson.M{"followedOn": time.Now()}}
+	_, err = coll.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func RemoveFollow(followerId, followeeId string, coll *mongo.Collection)(*mongo.DeleteResult, error){
+	ids, err := toObjectIDs(followerId, followeeId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{"followerId": ids[0], "followeeId": ids[1]}
+	return coll.DeleteOne(context.TODO(), filter)
+}
+
+/*
+Drops follow edges in both directions between the users
+*/
+func RemoveFollowsBetween(userId, otherId string, coll *mongo.Collection) error{
+	ids, err := toObjectIDs(userId, otherId)
+	if err != nil{
+		return err
+	}
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"followerId": ids[0], "followeeId": ids[1]},
+			bson.M{"followerId": ids[1], "followeeId": ids[0]},
+		},
+	}
+	_, err = coll.DeleteMany(context.TODO(), filter)
+	return err
+}
+
+// Returns ids of every user followed by @followerId
+func GetFolloweeIds(followerId string, coll *mongo.Collection)([]string, error){
+	id, err := primitive.ObjectIDFromHex(followerId)
+	if err != nil{
+		return nil, err
+	}
+	cursor, err := coll.Find(context.TODO(), bson.M{"followerId": id})
+	if err != nil{
+		return nil, err
+	}
+	var follows []Follow
+	if err := cursor.All(context.TODO(), &follows); err != nil{
+		return nil, err
+	}
+	followeeIds := make([]string, 0, len(follows))
+	for _, follow := range follows{
+		followeeIds = append(followeeIds, follow.FolloweeId.Hex())
+	}
+	return followeeIds, nil
+}
+
+func IsFollowing(followerId, followeeId string, coll *mongo.Collection)(bool, error){
+	followed, err := GetFollowedAmong(followerId, []string{followeeId}, coll)
+	return followed[followeeId], err
+}
+
+/*
+Returns which of @userIds are followed by @followerId
+*/
+func GetFollowedAmong(followerId string, userIds []string, coll *mongo.Collection)(map[string]bool, error){
+	followed := make(map[string]bool)
+	follower, err := primitive.ObjectIDFromHex(followerId)
+	if err != nil || len(userIds) == 0{
+		return followed, nil
+	}
+	ids, err := toObjectIDs(userIds...)
+	if err != nil{
+		return followed, err
+	}
+	filter := bson.M{"followerId": follower, "followeeId": bson.M{"$in": ids}}
+	cursor, err := coll.Find(context.TODO(), filter)
+	if err != nil{
+		return followed, err
+	}
+	var follows []Follow
+	if err := cursor.All(context.TODO(), &follows); err != nil{
+		return followed, err
+	}
+	for _, follow := range follows{
+		followed[follow.FolloweeId.Hex()] = true
+	}
+	return followed, nil
+}
+
+// Returns number of followers of the user and number of users they follow
+func CountFollows(userId string, coll *mongo.Collection)(int64, int64, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return 0, 0, err
+	}
+	followers, err := coll.CountDocuments(context.TODO(), bson.M{"followeeId": id})
+	if err != nil{
+		return 0, 0, err
+	}
+	following, err := coll.CountDocuments(context.TODO(), bson.M{"followerId": id})
+	return followers, following, err
+}
+
+/*
+Returns @page (starting from 1) of edges pointing to the user, latest first
+*/
+func GetFollowers(userId string, page, pageSize int64, coll *mongo.Collection)([]Follow, error){
+	return getFollows("followeeId", userId, page, pageSize, coll)
+}
+
+/*
+Returns @page (starting from 1) of edges going out of the user, latest first
+*/
+func GetFollowing(userId string, page, pageSize int64, coll *mongo.Collection)([]Follow, error){
+	return getFollows("followerId", userId, page, pageSize, coll)
+}
+
+func getFollows(field, userId string, page, pageSize int64, coll *mongo.Collection)([]Follow, error){
+	follows := make([]Follow, 0)
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return follows, err
+	}
+	// Id breaks ties so follows made at the same time keep their page
+	opts := options.Find().SetSort(bson.D{{Key: "followedOn", Value: -1}, {Key: "_id", Value: -1}}).SetSkip((page-1)*pageSize).SetLimit(pageSize)
+	cursor, err := coll.Find(context.TODO(), bson.M{field: id}, opts)
+	if err != nil{
+		return follows, err
+	}
+	err = cursor.All(context.TODO(), &follows)
+	return follows, err
+}
+
+func EnsureFollowIndexes(coll *mongo.Collection) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "followerId", Value: 1}, {Key: "followeeId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "followeeId", Value: 1}, {Key: "followedOn", Value: -1}}},
+		{Keys: bson.D{{Key: "followerId", Value: 1}, {Key: "followedOn", Value: -1}}},
+	}
+	_, err := coll.Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Failed attempts are forgotten after this long without a new failure
+const LOGINATTEMPTRETENTION = time.Hour*24
+
+/*
+After Threshold consecutive failures the key is locked for BaseDelay,
+doubling with every further failure up to MaxDelay
+*/
+type LockoutPolicy struct{
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay time.Duration
+}
+
+var(
+	// Per account, also used for second factor codes
+	ACCOUNTLOCKOUT = LockoutPolicy{Threshold: 5, BaseDelay: time.Second*30, MaxDelay: time.Hour}
+	// Per ip, higher threshold as many users may share an ip
+	IPLOCKOUT = LockoutPolicy{Threshold: 20, BaseDelay: time.Second*30, MaxDelay: time.Hour}
+	// Every sign in link sent counts, so nobody can flood an inbox or the mail server
+	MAGICLINKEMAILLIMIT = LockoutPolicy{Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}
+	MAGICLINKIPLIMIT = LockoutPolicy{Threshold: 10, BaseDelay: time.Minute, MaxDelay: time.Hour}
+)
+
+// Failed sign in attempts of a key, e.g. "email:<email>" or "ip:<ip>"
+type LoginAttempt struct{
+	Key string `json:"key" bson:"key"`
+	Failures int `json:"failures" bson:"failures"`
+	LastFailureOn time.Time `json:"lastFailureOn" bson:"lastFailureOn"`
+	LockedUntil time.Time `json:"lockedUntil" bson:"lockedUntil"`
+}
+
+func (policy LockoutPolicy) lockDuration(failures int) time.Duration{
+	if failures < policy.Threshold{
+		return 0
+	}
+	delay := policy.BaseDelay
+	for i := policy.Threshold; i < failures && delay < policy.MaxDelay; i++{
+		delay *= 2
+	}
+	if delay > policy.MaxDelay{
+		delay = policy.MaxDelay
+	}
+	return delay
+}
+
+/*
+Returns time until which @key is locked, zero time if it isn't
+*/
+func GetLockedUntil(key string, coll *mongo.Collection)(time.Time, error){
+	var attempt LoginAttempt
+	err := coll.FindOne(context.TODO(), bson.M{"key": key}).Decode(&attempt)
+	if err == mongo.ErrNoDocuments{
+		return time.Time{}, nil
+	}
+	if err != nil{
+		return time.Time{}, err
+	}
+	if attempt.LockedUntil.After(time.Now()){
+		return attempt.LockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+/*
+Counts failed attempt of @key and locks it according to @policy
+*/
+func RecordLoginFailure(key string, policy LockoutPolicy, coll *mongo.Collection) error{
+	now := time.Now()
+	filter := bson.M{"key": key}
+	update := bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"lastFailureOn": now},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var attempt LoginAttempt
+	if err := coll.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&attempt); err != nil{
+		return err
+	}
+
+	delay := policy.lockDuration(attempt.Failures)
+	if delay == 0{
+		return nil
+	}
+	_, err := coll.UpdateOne(context.TODO(), filter, bson.M{"$set": bson.M{"lockedUntil": now.Add(delay)}})
+	return err
+}
+
+func ClearLoginAttempts(key string, coll *mongo.Collection) error{
+	_, err := coll.DeleteOne(context.TODO(), bson.M{"key": key})
+	return err
+}
+
+// Stale attempts are removed by mongo itself through TTL index
+func EnsureLoginAttemptIndexes(coll *mongo.Collection) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"key": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"lastFailureOn": 1}, Options: options.Index().SetExpireAfterSeconds(int32(LOGINATTEMPTRETENTION.Seconds()))},
+	}
+	_, err := coll.Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// What a one time token can be exchanged for
+type TokenPurpose string
+
+const (
+	PURPOSEEMAILVERIFICATION TokenPurpose = "emailVerification"
+	PURPOSEPASSWORDRESET TokenPurpose = "passwordReset"
+	PURPOSEOIDCSTATE TokenPurpose = "oidcState"
+	PURPOSEEMAILCHANGE TokenPurpose = "emailChange"
+	PURPOSEEMAILCHANGECANCEL TokenPurpose = "emailChangeCancel"
+	PURPOSEMAGICLINK TokenPurpose = "magicLink"
+	PURPOSEDATAEXPORT TokenPurpose = "dataExport"
+)
+
+// Single use token sent to the user, e.g. in email links
+// Actual data that will be added to the db, only the hash of the token is stored
+type OneTimeTokenIntermediate struct{
+	UserId string `json:"userId" bson:"userId"`
+	Purpose TokenPurpose `json:"purpose" bson:"purpose"`
+	TokenHash string `json:"-" bson:"tokenHash"`
+	Data map[string]string `json:"data,omitempty" bson:"data,omitempty"`
+	CreatedOn time.Time `json:"createdOn" bson:"createdOn"`
+	ExpiresOn time.Time `json:"expiresOn" bson:"expiresOn"`
+	Used bool `json:"used" bson:"used"`
+}
+
+// Full data that is stored in db
+type OneTimeToken struct{
+	ID string `json:"_id" bson:"_id"`
+	UserId string `json:"userId" bson:"userId"`
+	Purpose TokenPurpose `json:"purpose" bson:"purpose"`
+	TokenHash string `json:"-" bson:"tokenHash"`
+	Data map[string]string `json:"data,omitempty" bson:"data,omitempty"`
+	CreatedOn time.Time `json:"createdOn" bson:"createdOn"`
+	ExpiresOn time.Time `json:"expiresOn" bson:"expiresOn"`
+	UsedOn time.Time `json:"usedOn,omitempty" bson:"usedOn,omitempty"`
+	Used bool `json:"used" bson:"used"`
+}
+
+func (token *OneTimeTokenIntermediate) AddOneTimeToken(coll *mongo.Collection)(*mongo.InsertOneResult, error){
+	return coll.InsertOne(context.TODO(), token)
+}
+
+/*
+Finds unused and unexpired token with @tokenHash issued for @purpose without consuming it
+*/
+func GetOneTimeToken(tokenHash string, purpose TokenPurpose, coll *mongo.Collection)(*OneTimeToken, error){
+	filter := bson.M{
+		"tokenHash": tokenHash,
+		"purpose": purpose,
+		"used": false,
+		"expiresOn": bson.M{"$gt": time.Now()},
+	}
+	var token OneTimeToken
+	if err := coll.FindOne(context.TODO(), filter).Decode(&token); err != nil{
+		return nil, errors.New("invalid or expired token")
+	}
+	return &token, nil
+}
+
+/*
+Consumes unused and unexpired token with @tokenHash issued for @purpose
+*/
+func UseOneTimeToken(tokenHash string, purpose TokenPurpose, coll *mongo.Collection)(*OneTimeToken, error){
+	now := time.Now()
+	filter := bson.M{
+		"tokenHash": tokenHash,
+		"purpose": purpose,
+		"used": false,
+		"expiresOn": bson.M{"$gt": now},
+	}
+	update := bson.M{"$set": bson.M{"used": true, "usedOn": now}}
+	var token OneTimeToken
+	if err := coll.FindOneAndUpdate(context.TODO(), filter, update).Decode(&token); err != nil{
+		return nil, errors.New("invalid or expired token")
+	}
+	return &token, nil
+}
+
+/*
+Marks every unused token of @userId for @purpose as used
+So that only the latest token sent to the user works
+*/
+func InvalidateOneTimeTokens(userId string, purpose TokenPurpose, coll *mongo.Collection) error{
+	filter := bson.M{"userId": userId, "purpose": purpose, "used": false}
+	update := bson.M{"$set": bson.M{"used": true, "usedOn": time.Now()}}
+	_, err := coll.UpdateMany(context.TODO(), filter, update)
+	return err
+}
+
+/*
+Marks every unused sign in link sent to @email as used
+Links for emails without account have no user, so they're matched on email instead
+*/
+func InvalidateMagicLinks(email string, coll *mongo.Collection) error{
+	filter := bson.M{"purpose": PURPOSEMAGICLINK, "data.email": email, "used": false}
+	update := bson.M{"$set": bson.M{"used": true, "usedOn": time.Now()}}
+	_, err := coll.UpdateMany(context.TODO(), filter, update)
+	return err
+}
+
+// Expired tokens are removed by mongo itself through TTL index
+func EnsureOneTimeTokenIndexes(coll *mongo.Collection) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"tokenHash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "purpose", Value: 1}}},
+		{Keys: bson.D{{Key: "purpose", Value: 1}, {Key: "data.email", Value: 1}}},
+		{Keys: bson.M{"expiresOn": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+	_, err := coll.Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
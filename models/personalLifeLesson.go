@@ -23,6 +23,7 @@ type PersonalLifeLessonRequest struct {
 	Learning     string   `json:"learning" bson:"learning"`
 	RelatedStory string   `json:"relatedStory" bson:"relatedStory"`
 	CategoryId   string   `json:"categoryId" bson:"categoryId"`
+	Anonymous    bool     `json:"anonymous" bson:"anonymous"`
 }
 
 type PersonalLifeLessonUpdateRequest struct {
@@ -31,8 +32,12 @@ type PersonalLifeLessonUpdateRequest struct {
 	Learning     string   `json:"learning" bson:"learning"`
 	RelatedStory string   `json:"relatedStory" bson:"relatedStory"`
 	CategoryId   string   `json:"categoryId" bson:"categoryId"`
+	Anonymous    bool     `json:"anonymous" bson:"anonymous"`
 }
 
+/*
+Anonymous posts are still owned by userId, username holds pseudonym of the author
+*/
 type PersonalLifeLessonRequestIntermediate struct {
 	UserId       string   `json:"userId" bson:"userId"`
 	Username     string   `json:"username" bson:"username"`
@@ -41,6 +46,7 @@ type PersonalLifeLessonRequestIntermediate struct {
 	RelatedStory string   `json:"relatedStory" bson:"relatedStory"`
 	CreatedOn    time.Time   `json:"createdOn" bson:"createdOn"` // int64
 	CategoryId   string   `json:"categoryId" bson:"categoryId"`
+	Anonymous    bool     `json:"anonymous" bson:"anonymous"`
 }
 
 type PersonalLifeLesson struct {
@@ -54,6 +60,17 @@ type PersonalLifeLesson struct {
 	CategoryId   string   `json:"categoryId" bson:"categoryId"`
 	Likes        []string `json:"likes" bson:"likes"`
 	Comments     []string `json:"comments" bson:"comments"`
+	Anonymous    bool     `json:"anonymous" bson:"anonymous"`
+}
+
+/*
+Removes link to the author of anonymous post
+Only the pseudonym in username is left
+*/
+func (pll *PersonalLifeLesson) HideAuthor(){
+	if pll.Anonymous{
+		pll.UserId = ""
+	}
 }
 
 func DeletePll(pllId string, coll *mongo.Collection) error{
@@ -81,6 +98,8 @@ func (pll *PersonalLifeLessonRequest)ToPersonalLifeLessonRequestIntermediate(use
 		RelatedStory: pll.RelatedStory,
 		// CreatedOn: time.Now().Unix(),
 		CreatedOn: time.Now(),
+		CategoryId: pll.CategoryId,
+		Anonymous: pll.Anonymous,
 	}
 }
 
@@ -109,6 +128,7 @@ func (pll *PersonalLifeLessonUpdateRequest) UpdatePll(userId, username string, c
 			"learning" : pll.Learning,
 			"relatedStory" : pll.RelatedStory,
 			"categoryId" : pll.CategoryId,
+			"anonymous" : pll.Anonymous,
 		},
 	}
 	return coll.UpdateOne(context.TODO(), filter, update)
@@ -116,12 +136,16 @@ func (pll *PersonalLifeLessonUpdateRequest) UpdatePll(userId, username string, c
 
 /*
 Returns all Personal Life Lesson posts
+Posts of @hiddenUserIds (e.g. banned users) are left out
 */
-func GetPlls(coll *mongo.Collection)([]PersonalLifeLesson, error){
+func GetPlls(hiddenUserIds []string, coll *mongo.Collection)([]PersonalLifeLesson, error){
 	plls := make([]PersonalLifeLesson, 0)
 
 	opts := options.Find().SetSort(bson.M{"_id": -1})
 	filter := bson.M{}
+	if len(hiddenUserIds) > 0{
+		filter["userId"] = bson.M{"$nin": hiddenUserIds}
+	}
 	cursor, err := coll.Find(context.TODO(), filter, opts)
 	if err != nil{
 		return plls , nil
@@ -131,6 +155,61 @@ func GetPlls(coll *mongo.Collection)([]PersonalLifeLesson, error){
 	return plls,err 
 }
 
+/*
+Returns up to @limit lessons matching @filter, latest first
+Only lessons older than @before are returned unless it is zero
+*/
+func GetLatestPlls(filter bson.M, before primitive.ObjectID, limit int64, coll *mongo.Collection)([]PersonalLifeLesson, error){
+	plls := make([]PersonalLifeLesson, 0)
+	if !before.IsZero(){
+		filter = bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$lt": before}}}}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(limit)
+	cursor, err := coll.Find(context.TODO(), filter, opts)
+	if err != nil{
+		return plls, err
+	}
+	err = cursor.All(context.TODO(), &plls)
+	return plls, err
+}
+
+// Position after the last lesson of a page ranked by likes
+type PopularityCursor struct{
+	LikeCount int64
+	LastId primitive.ObjectID
+}
+
+/*
+Returns up to @limit lessons matching @filter, most liked first
+Lessons with equal likes are ordered latest first
+Only lessons ranked after @after are returned unless it is nil
+*/
+func GetPopularPlls(filter bson.M, after *PopularityCursor, limit int64, coll *mongo.Collection)([]PersonalLifeLesson, error){
+	plls := make([]PersonalLifeLesson, 0)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$addFields", Value: bson.M{"likeCount": bson.M{"$size": bson.M{"$ifNull": bson.A{"$likes", bson.A{}}}}}}},
+	}
+	if after != nil{
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{
+			"$or": bson.A{
+				bson.M{"likeCount": bson.M{"$lt": after.LikeCount}},
+				bson.M{"likeCount": after.LikeCount, "_id": bson.M{"$lt": after.LastId}},
+			},
+		}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "likeCount", Value: -1}, {Key: "_id", Value: -1}}}},
+		bson.D{{Key: "$limit", Value: limit}},
+	)
+	cursor, err := coll.Aggregate(context.TODO(), pipeline)
+	if err != nil{
+		return plls, err
+	}
+	err = cursor.All(context.TODO(), &plls)
+	return plls, err
+}
+
 /*
 Returns @pllId corresponding Personal Life Lesson post
 */
@@ -175,4 +254,14 @@ func DislikePlls(pllIds []string, userId string, pllColl *mongo.Collection){
 	for _, id := range pllObjectIds{
 		go pllColl.UpdateOne(context.TODO(), bson.M{"_id":id}, bson.M{"$pull":bson.M{"likes":userId}})
 	}
-}
\ No newline at end of file
+}
+
+// Lessons are listed per author on profiles and per category in feeds
+func EnsurePllIndexes(coll *mongo.Collection) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "categoryId", Value: 1}, {Key: "_id", Value: -1}}},
+	}
+	_, err := coll.Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
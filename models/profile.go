@@ -0,0 +1,158 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const MAXBIOLENGTH = 300
+
+// Handles are lowercase so that they are unique regardless of case
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,30}$`)
+
+// Request body from user when updating their public profile
+type ProfileUpdateRequest struct{
+	Handle string `json:"handle"`
+	Bio string `json:"bio"`
+}
+
+/*
+Public part of a user, safe to show to anyone
+Anonymous lessons are never counted
+*/
+type Profile struct{
+	ID string `json:"_id"`
+	Username string `json:"username"`
+	Handle string `json:"handle,omitempty"`
+	Photo string `json:"photo,omitempty"`
+	Bio string `json:"bio,omitempty"`
+	JoinedOn time.Time `json:"joinedOn"`
+	LessonCount int64 `json:"lessonCount"`
+	LikeCount int64 `json:"likeCount"`
+	FollowerCount int64 `json:"followerCount"`
+	FollowingCount int64 `json:"followingCount"`
+}
+
+func (request *ProfileUpdateRequest) Validate() error{
+	request.Handle = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(request.Handle), "@"))
+	if request.Handle != "" && !handlePattern.MatchString(request.Handle){
+		return errors.New("handle should be 3 to 30 letters, digits or underscores")
+	}
+	if len([]rune(request.Bio)) > MAXBIOLENGTH{
+		return errors.New("bio should be at most 300 characters long")
+	}
+	return nil
+}
+
+/*
+Empty handle removes it
+Returns mongo duplicate key error when the handle is taken
+*/
+func (request *ProfileUpdateRequest) UpdateProfile(userId string, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{"_id": id}
+	update := bson.M{"$set": bson.M{"bio": request.Bio}}
+	if request.Handle == ""{
+		update["$unset"] = bson.M{"handle": ""}
+	}else{
+		update["$set"].(bson.M)["handle"] = request.Handle
+	}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+func GetUserByHandle(handle string, coll *mongo.Collection)(*User, error){
+	filter := bson.M{"handle": strings.ToLower(strings.TrimPrefix(handle, "@"))}
+	var user User
+	if err := coll.FindOne(context.TODO(), filter).Decode(&user); err != nil{
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (user *User) ToProfile() *Profile{
+	return &Profile{
+		ID: user.ID,
+		Username: user.Username,
+		Handle: user.Handle,
+		Photo: user.Photo,
+		Bio: user.Bio,
+		JoinedOn: user.JoinedOn,
+	}
+}
+
+// Lessons shown on the profile of @userId, anonymous ones are left out
+func publicPllsFilter(userId string) bson.M{
+	return bson.M{"userId": userId, "anonymous": bson.M{"$ne": true}}
+}
+
+/*
+Fills number of public lessons of the user and likes they received on them
+*/
+func (profile *Profile) CountLessons(pllColl *mongo.Collection) error{
+	lessonCount, err := pllColl.CountDocuments(context.TODO(), publicPllsFilter(profile.ID))
+	if err != nil{
+		return err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: publicPllsFilter(profile.ID)}},
+		{{Key: "$group", Value: bson.M{
+			"_id": nil,
+			"likes": bson.M{"$sum": bson.M{"$size": bson.M{"$ifNull": bson.A{"$likes", bson.A{}}}}},
+		}}},
+	}
+	cursor, err := pllColl.Aggregate(context.TODO(), pipeline)
+	if err != nil{
+		return err
+	}
+	var result []struct{
+		Likes int64 `bson:"likes"`
+	}
+	if err := cursor.All(context.TODO(), &result); err != nil{
+		return err
+	}
+
+	profile.LessonCount = lessonCount
+	if len(result) > 0{
+		profile.LikeCount = result[0].Likes
+	}
+	return nil
+}
+
+/*
+Returns @page (starting from 1) of public lessons of the user, latest first
+*/
+func GetUserPlls(userId string, page, pageSize int64, coll *mongo.Collection)([]PersonalLifeLesson, error){
+	plls := make([]PersonalLifeLesson, 0)
+
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetSkip((page-1)*pageSize).SetLimit(pageSize)
+	cursor, err := coll.Find(context.TODO(), publicPllsFilter(userId), opts)
+	if err != nil{
+		return plls, err
+	}
+	err = cursor.All(context.TODO(), &plls)
+	return plls, err
+}
+
+func EnsureUserIndexes(coll *mongo.Collection) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"email": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"handle": 1}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{Keys: bson.M{"deletionScheduledOn": 1}, Options: options.Index().SetSparse(true)},
+		// Only banned users are indexed, they're few compared to everyone
+		{Keys: bson.D{{Key: "banned", Value: 1}, {Key: "contentHidden", Value: 1}}, Options: options.Index().SetPartialFilterExpression(bson.M{"banned": true})},
+	}
+	_, err := coll.Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrRefreshTokenReused = errors.New("refresh token already used, all tokens of this login are revoked")
+
+// Actual data that will be added to the db
+// Only the hash of the opaque token is stored
+type RefreshTokenIntermediate struct{
+	UserId string `json:"userId" bson:"userId"`
+	FamilyId string `json:"familyId" bson:"familyId"`
+	TokenHash string `json:"-" bson:"tokenHash"`
+	CreatedOn time.Time `json:"createdOn" bson:"createdOn"`
+	ExpiresOn time.Time `json:"expiresOn" bson:"expiresOn"`
+	Used bool `json:"used" bson:"used"`
+	Revoked bool `json:"revoked" bson:"revoked"`
+}
+
+// Full data that is stored in db
+type RefreshToken struct{
+	ID string `json:"_id" bson:"_id"`
+	UserId string `json:"userId" bson:"userId"`
+	FamilyId string `json:"familyId" bson:"familyId"`
+	TokenHash string `json:"-" bson:"tokenHash"`
+	CreatedOn time.Time `json:"createdOn" bson:"createdOn"`
+	ExpiresOn time.Time `json:"expiresOn" bson:"expiresOn"`
+	UsedOn time.Time `json:"usedOn,omitempty" bson:"usedOn,omitempty"`
+	Used bool `json:"used" bson:"used"`
+	Revoked bool `json:"revoked" bson:"revoked"`
+}
+
+func (token *RefreshTokenIntermediate) AddRefreshToken(coll *mongo.Collection)(*mongo.InsertOneResult, error){
+	return coll.InsertOne(context.TODO(), token)
+}
+
+/*
+Marks the refresh token with @tokenHash as used and returns it.
+Presenting an already used token means it was stolen (or replayed),
+so the whole family is revoked and ErrRefreshTokenReused is returned
+along with the reused token
+*/
+func UseRefreshToken(tokenHash string, coll *mongo.Collection)(*RefreshToken, error){
+	var token RefreshToken
+
+	// Atomically consuming the token so that it can only be rotated once
+	filter := bson.M{"tokenHash": tokenHash, "used": false, "revoked": false}
+	update := bson.M{"$set": bson.M{"used": true, "usedOn": time.Now()}}
+	err := coll.FindOneAndUpdate(context.TODO(), filter, update).Decode(&token)
+	if err == nil{
+		if token.ExpiresOn.Before(time.Now()){
+			return nil, errors.New("refresh token expired")
+		}
+		return &token, nil
+	}
+	if err != mongo.ErrNoDocuments{
+		return nil, err
+	}
+
+	// Token is either unknown, already used or revoked
+	if err := coll.FindOne(context.TODO(), bson.M{"tokenHash": tokenHash}).Decode(&token); err != nil{
+		return nil, errors.New("invalid refresh token")
+	}
+	if token.Used{
+		if err := RevokeRefreshTokenFamily(token.FamilyId, coll); err != nil{
+			return nil, err
+		}
+		return &token, ErrRefreshTokenReused
+	}
+	return nil, errors.New("refresh token revoked")
+}
+
+func RevokeRefreshTokenFamily(familyId string, coll *mongo.Collection) error{
+	filter := bson.M{"familyId": familyId}
+	update := bson.M{"$set": bson.M{"revoked": true}}
+	_, err := coll.UpdateMany(context.TODO(), filter, update)
+	return err
+}
+
+func RevokeUserRefreshTokens(userId string, coll *mongo.Collection) error{
+	filter := bson.M{"userId": userId}
+	update := bson.M{"$set": bson.M{"revoked": true}}
+	_, err := coll.UpdateMany(context.TODO(), filter, update)
+	return err
+}
+
+// Expired refresh tokens are removed by mongo itself through TTL index
+func EnsureRefreshTokenIndexes(coll *mongo.Collection) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"tokenHash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"familyId": 1}},
+		{Keys: bson.M{"userId": 1}},
+		{Keys: bson.M{"expiresOn": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+	_, err := coll.Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func refreshTokenDocument(used, revoked bool, expiresOn time.Time) bson.D{
+	return bson.D{
+		{Key: "_id", Value: "token"},
+		{Key: "userId", Value: "user"},
+		{Key: "familyId", Value: "family"},
+		{Key: "tokenHash", Value: "hash"},
+		{Key: "expiresOn", Value: expiresOn},
+		{Key: "used", Value: used},
+		{Key: "revoked", Value: revoked},
+	}
+}
+
+func TestUseRefreshToken(t *testing.T){
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	namespace := "db.RefreshTokens"
+
+	mt.Run("unused token is consumed", func(mt *mtest.T){
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: refreshTokenDocument(false, false, time.Now().Add(time.Hour))}))
+		token, err := UseRefreshToken("hash", mt.Coll)
+		if err != nil || token.FamilyId != "family"{
+			mt.Fatalf("expected token of the family, got %v %v", token, err)
+		}
+	})
+
+	mt.Run("expired token is rejected", func(mt *mtest.T){
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: refreshTokenDocument(false, false, time.Now().Add(-time.Hour))}))
+		if _, err := UseRefreshToken("hash", mt.Coll); err == nil{
+			mt.Fatal("expected expired token to be rejected")
+		}
+	})
+
+	mt.Run("reused token revokes its family", func(mt *mtest.T){
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			mtest.CreateCursorResponse(0, namespace, mtest.FirstBatch, refreshTokenDocument(true, false, time.Now().Add(time.Hour))),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 2}),
+		)
+		token, err := UseRefreshToken("hash", mt.Coll)
+		if err != ErrRefreshTokenReused{
+			mt.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+		}
+		if token == nil || token.FamilyId != "family"{
+			mt.Fatalf("expected reused token to be returned, got %v", token)
+		}
+
+		// Last command revokes every token of the family
+		event := mt.GetAllStartedEvents()[2]
+		if event.CommandName != "update"{
+			mt.Fatalf("expected update, got %v", event.CommandName)
+		}
+		update := event.Command.Lookup("updates").Array().Index(0).Value().Document()
+		if familyId := update.Lookup("q", "familyId").StringValue(); familyId != "family"{
+			mt.Fatalf("expected family to be revoked, got %q", familyId)
+		}
+		if !update.Lookup("u", "$set", "revoked").Boolean() || !update.Lookup("multi").Boolean(){
+			mt.Fatalf("expected every token of the family to be revoked, got %v", update)
+		}
+	})
+
+	mt.Run("revoked token is rejected without revoking again", func(mt *mtest.T){
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			mtest.CreateCursorResponse(0, namespace, mtest.FirstBatch, refreshTokenDocument(false, true, time.Now().Add(time.Hour))),
+		)
+		token, err := UseRefreshToken("hash", mt.Coll)
+		if err == nil || err == ErrRefreshTokenReused || token != nil{
+			mt.Fatalf("expected revoked token to be rejected, got %v %v", token, err)
+		}
+		if count := len(mt.GetAllStartedEvents()); count != 2{
+			mt.Fatalf("expected no further commands, got %d", count)
+		}
+	})
+
+	mt.Run("unknown token is rejected", func(mt *mtest.T){
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+			mtest.CreateCursorResponse(0, namespace, mtest.FirstBatch),
+		)
+		if token, err := UseRefreshToken("hash", mt.Coll); err == nil || token != nil{
+			mt.Fatalf("expected unknown token to be rejected, got %v %v", token, err)
+		}
+	})
+}
@@ -8,4 +8,12 @@ type ErrorResponse struct{
 
 type GeneralResponse struct{
 	Message string `json:"message"`
-}
\ No newline at end of file
+}
+
+// Returned on every successful sign up / sign in
+type TokenResponse struct{
+	Token string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn int64 `json:"expiresIn"`
+	UserId string `json:"userId"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Access token revoked before its expiry, identified by its jti claim
+Kept only until the token would have expired anyway
+*/
+type RevokedToken struct{
+	TokenId string `json:"tokenId" bson:"tokenId"`
+	RevokedOn time.Time `json:"revokedOn" bson:"revokedOn"`
+	ExpiresOn time.Time `json:"expiresOn" bson:"expiresOn"`
+}
+
+// Revoking the same token twice keeps a single entry
+func AddRevokedToken(tokenId string, expiresOn time.Time, coll *mongo.Collection) error{
+	filter := bson.M{"tokenId": tokenId}
+	update := bson.M{
+		"$setOnInsert": bson.M{
+			"tokenId": tokenId,
+			"revokedOn": time.Now(),
+			"expiresOn": expiresOn,
+		},
+	}
+	_, err := coll.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func IsTokenRevoked(tokenId string, coll *mongo.Collection)(bool, error){
+	filter := bson.M{"tokenId": tokenId, "expiresOn": bson.M{"$gt": time.Now()}}
+	count, err := coll.CountDocuments(context.TODO(), filter, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// Expired entries are removed by mongo itself through TTL index
+func EnsureRevokedTokenIndexes(coll *mongo.Collection) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.M{"tokenId": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"expiresOn": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+	_, err := coll.Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Request body from admin when changing role of a user
+type RoleChangeRequest struct{
+	UserId string `json:"userId" bson:"userId"`
+	Role Role `json:"role" bson:"role"`
+	Reason string `json:"reason" bson:"reason"`
+}
+
+// Actual data that will be added to the db
+type RoleChangeIntermediate struct{
+	UserId string `json:"userId" bson:"userId"`
+	ChangedBy string `json:"changedBy" bson:"changedBy"`
+	From Role `json:"from" bson:"from"`
+	To Role `json:"to" bson:"to"`
+	Reason string `json:"reason" bson:"reason"`
+	ChangedOn time.Time `json:"changedOn" bson:"changedOn"`
+}
+
+// Full data that is stored in db
+type RoleChange struct{
+	ID string `json:"_id" bson:"_id"`
+	UserId string `json:"userId" bson:"userId"`
+	ChangedBy string `json:"changedBy" bson:"changedBy"`
+	From Role `json:"from" bson:"from"`
+	To Role `json:"to" bson:"to"`
+	Reason string `json:"reason" bson:"reason"`
+	ChangedOn time.Time `json:"changedOn" bson:"changedOn"`
+}
+
+func (request *RoleChangeRequest) ToRoleChangeIntermediate(changedBy string, from Role) *RoleChangeIntermediate{
+	return &RoleChangeIntermediate{
+		UserId: request.UserId,
+		ChangedBy: changedBy,
+		From: from,
+		To: request.Role,
+		Reason: request.Reason,
+		ChangedOn: time.Now(),
+	}
+}
+
+func (roleChange *RoleChangeIntermediate) AddRoleChange(coll *mongo.Collection)(*mongo.InsertOneResult, error){
+	return coll.InsertOne(context.TODO(), roleChange)
+}
+
+/*
+Returns role changes, latest first
+Empty @userId returns changes of every user
+*/
+func GetRoleChanges(userId string, coll *mongo.Collection)([]RoleChange, error){
+	roleChanges := make([]RoleChange, 0)
+
+	filter := bson.M{}
+	if userId != ""{
+		filter["userId"] = userId
+	}
+	opts := options.Find().SetSort(bson.M{"changedOn": -1})
+	cursor, err := coll.Find(context.TODO(), filter, opts)
+	if err != nil{
+		return roleChanges, err
+	}
+	err = cursor.All(context.TODO(), &roleChanges)
+	return roleChanges, err
+}
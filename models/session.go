@@ -0,0 +1,155 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Session is refreshed at most once in this duration to avoid writing on every request
+const SESSIONTOUCHINTERVAL = time.Minute
+
+// One session per signed in device
+// Session ends together with its latest refresh token, mongo drops it after expiresOn
+// Actual data that will be added to the db
+type SessionIntermediate struct{
+	UserId string `json:"userId" bson:"userId"`
+	UserAgent string `json:"userAgent" bson:"userAgent"`
+	IP string `json:"ip" bson:"ip"`
+	CreatedOn time.Time `json:"createdOn" bson:"createdOn"`
+	LastSeenOn time.Time `json:"lastSeenOn" bson:"lastSeenOn"`
+	ExpiresOn time.Time `json:"expiresOn" bson:"expiresOn"`
+	Revoked bool `json:"revoked" bson:"revoked"`
+}
+
+// Full data that is stored in db
+type Session struct{
+	ID string `json:"_id" bson:"_id"`
+	UserId string `json:"userId" bson:"userId"`
+	UserAgent string `json:"userAgent" bson:"userAgent"`
+	IP string `json:"ip" bson:"ip"`
+	CreatedOn time.Time `json:"createdOn" bson:"createdOn"`
+	LastSeenOn time.Time `json:"lastSeenOn" bson:"lastSeenOn"`
+	ExpiresOn time.Time `json:"expiresOn" bson:"expiresOn"`
+	Revoked bool `json:"revoked" bson:"revoked"`
+}
+
+func NewSessionIntermediate(userId, userAgent, ip string, expiresOn time.Time) *SessionIntermediate{
+	now := time.Now()
+	return &SessionIntermediate{
+		UserId: userId,
+		UserAgent: userAgent,
+		IP: ip,
+		CreatedOn: now,
+		LastSeenOn: now,
+		ExpiresOn: expiresOn,
+	}
+}
+
+func (session *SessionIntermediate) AddSession(coll *mongo.Collection)(*mongo.InsertOneResult, error){
+	return coll.InsertOne(context.TODO(), session)
+}
+
+/*
+Returns @sessionId corresponding session if it belongs to @userId and is neither revoked nor expired
+*/
+func GetActiveSession(sessionId, userId string, coll *mongo.Collection)(*Session, error){
+	id, err := primitive.ObjectIDFromHex(sessionId)
+	if err != nil{
+		return nil, errors.New("invalid session id")
+	}
+	filter := bson.M{"_id": id, "userId": userId, "revoked": false, "expiresOn": bson.M{"$gt": time.Now()}}
+	var session Session
+	if err := coll.FindOne(context.TODO(), filter).Decode(&session); err != nil{
+		return nil, errors.New("session expired or revoked, sign in again")
+	}
+	return &session, nil
+}
+
+/*
+Updates last seen details of the session
+*/
+func TouchSession(session *Session, ip string, coll *mongo.Collection) error{
+	if time.Since(session.LastSeenOn) < SESSIONTOUCHINTERVAL && session.IP == ip{
+		return nil
+	}
+	id, err := primitive.ObjectIDFromHex(session.ID)
+	if err != nil{
+		return err
+	}
+	update := bson.M{"$set": bson.M{"lastSeenOn": time.Now(), "ip": ip}}
+	_, err = coll.UpdateOne(context.TODO(), bson.M{"_id": id}, update)
+	return err
+}
+
+/*
+Moves end of the session to expiry of its newly issued refresh token
+*/
+func ExtendSession(sessionId string, expiresOn time.Time, coll *mongo.Collection) error{
+	id, err := primitive.ObjectIDFromHex(sessionId)
+	if err != nil{
+		return errors.New("invalid session id")
+	}
+	_, err = coll.UpdateOne(context.TODO(), bson.M{"_id": id}, bson.M{"$set": bson.M{"expiresOn": expiresOn}})
+	return err
+}
+
+/*
+Returns all active sessions of @userId, latest first
+*/
+func GetUserSessions(userId string, coll *mongo.Collection)([]Session, error){
+	sessions := make([]Session, 0)
+
+	opts := options.Find().SetSort(bson.M{"lastSeenOn": -1})
+	filter := bson.M{"userId": userId, "revoked": false, "expiresOn": bson.M{"$gt": time.Now()}}
+	cursor, err := coll.Find(context.TODO(), filter, opts)
+	if err != nil{
+		return sessions, err
+	}
+	err = cursor.All(context.TODO(), &sessions)
+	return sessions, err
+}
+
+func RevokeSession(sessionId, userId string, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(sessionId)
+	if err != nil{
+		return nil, errors.New("invalid session id")
+	}
+	filter := bson.M{"_id": id, "userId": userId}
+	update := bson.M{"$set": bson.M{"revoked": true}}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+func RevokeUserSessions(userId string, coll *mongo.Collection) error{
+	filter := bson.M{"userId": userId}
+	update := bson.M{"$set": bson.M{"revoked": true}}
+	_, err := coll.UpdateMany(context.TODO(), filter, update)
+	return err
+}
+
+/*
+Sessions started before they had an expiry end @lifetime after they were last seen,
+the longest their refresh token could have lived
+*/
+func SetMissingSessionExpiry(lifetime time.Duration, coll *mongo.Collection) error{
+	filter := bson.M{"expiresOn": bson.M{"$exists": false}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"expiresOn": bson.M{"$add": bson.A{"$lastSeenOn", lifetime.Milliseconds()}}}}},
+	}
+	_, err := coll.UpdateMany(context.TODO(), filter, update)
+	return err
+}
+
+func EnsureSessionIndexes(coll *mongo.Collection) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "lastSeenOn", Value: -1}}},
+		{Keys: bson.M{"expiresOn": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	}
+	_, err := coll.Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Saves secret of a started enrollment, 2FA stays disabled until it is confirmed
+*/
+func SetPendingTOTPSecret(userId, secret string, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{"_id": id, "totpEnabled": bson.M{"$ne": true}}
+	update := bson.M{"$set": bson.M{"totpSecret": secret, "totpLastStep": 0}}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+func EnableTOTP(userId string, recoveryCodeHashes []string, lastStep int64, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{"_id": id}
+	update := bson.M{
+		"$set": bson.M{
+			"totpEnabled": true,
+			"totpLastStep": lastStep,
+			"recoveryCodes": recoveryCodeHashes,
+		},
+	}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+func DisableTOTP(userId string, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{"_id": id}
+	update := bson.M{
+		"$set": bson.M{"totpEnabled": false},
+		"$unset": bson.M{"totpSecret": "", "totpLastStep": "", "recoveryCodes": ""},
+	}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+/*
+Records @step as used, fails if the same or a later step was already used
+So that an intercepted code can't be replayed
+*/
+func UseTOTPStep(userId string, step int64, coll *mongo.Collection)(bool, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return false, err
+	}
+	filter := bson.M{"_id": id, "totpLastStep": bson.M{"$lt": step}}
+	update := bson.M{"$set": bson.M{"totpLastStep": step}}
+	result, err := coll.UpdateOne(context.TODO(), filter, update)
+	if err != nil{
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+/*
+Removes recovery code with @codeHash, each code works only once
+*/
+func UseRecoveryCode(userId, codeHash string, coll *mongo.Collection)(bool, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return false, err
+	}
+	filter := bson.M{"_id": id, "recoveryCodes": codeHash}
+	update := bson.M{"$pull": bson.M{"recoveryCodes": codeHash}}
+	result, err := coll.UpdateOne(context.TODO(), filter, update)
+	if err != nil{
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
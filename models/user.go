@@ -3,6 +3,7 @@ package models
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -11,7 +12,17 @@ import (
 )
 
 
-const COST int = 5
+type Role string
+
+const (
+	ROLEUSER Role = "user"
+	ROLEMODERATOR Role = "moderator"
+	ROLEADMIN Role = "admin"
+)
+
+func (role Role) IsValid() bool{
+	return role == ROLEUSER || role == ROLEMODERATOR || role == ROLEADMIN
+}
 
 // For update only
 type UserUpdateRequest struct{
@@ -37,8 +48,9 @@ type UserIntermediate struct{
 	Password string `json:"password" bson:"password"`
 	Photo string `json:"photo,omitempty" bson:"photo"`
 	JoinedOn time.Time `json:"joinedOn" bson:"joinedOn"`
-	LastToken string `json:"-" bson:"token,omitempty"`
 	IsAdmin bool `json:"isAdmin" bson:"isAdmin"`
+	Role Role `json:"role" bson:"role"`
+	Verified bool `json:"verified" bson:"verified"`
 }
 
 type User struct{
@@ -47,37 +59,43 @@ type User struct{
 	Email string `json:"email" bson:"email"`
 	Password string `json:"-" bson:"password"`
 	Photo string `json:"photo,omitempty" bson:"photo,omitempty"`
+	Handle string `json:"handle,omitempty" bson:"handle,omitempty"`
+	Bio string `json:"bio,omitempty" bson:"bio,omitempty"`
 	JoinedOn time.Time `json:"joinedOn" bson:"joinedOn"`
-	LastToken string `json:"-" bson:"token,omitempty"`
+	PasswordChangedOn time.Time `json:"-" bson:"passwordChangedOn,omitempty"`
 	IsAdmin bool `json:"isAdmin" bson:"isAdmin"`
+	Role Role `json:"role,omitempty" bson:"role,omitempty"`
+	Verified bool `json:"verified" bson:"verified"`
+	TOTPEnabled bool `json:"totpEnabled" bson:"totpEnabled,omitempty"`
+	TOTPSecret string `json:"-" bson:"totpSecret,omitempty"`
+	TOTPLastStep int64 `json:"-" bson:"totpLastStep,omitempty"`
+	RecoveryCodes []string `json:"-" bson:"recoveryCodes,omitempty"`
+	Banned bool `json:"banned,omitempty" bson:"banned,omitempty"`
+	ContentHidden bool `json:"contentHidden,omitempty" bson:"contentHidden,omitempty"`
+	SuspendedUntil time.Time `json:"suspendedUntil,omitempty" bson:"suspendedUntil,omitempty"`
+	RestrictionReason string `json:"restrictionReason,omitempty" bson:"restrictionReason,omitempty"`
+	RestrictedBy string `json:"restrictedBy,omitempty" bson:"restrictedBy,omitempty"`
+	RestrictedOn time.Time `json:"restrictedOn,omitempty" bson:"restrictedOn,omitempty"`
+	DeletionRequestedOn time.Time `json:"deletionRequestedOn,omitempty" bson:"deletionRequestedOn,omitempty"`
+	DeletionScheduledOn time.Time `json:"deletionScheduledOn,omitempty" bson:"deletionScheduledOn,omitempty"`
 }
 
-func UpdateLastToken(email, token string, coll *mongo.Collection)(error){
-	filter := bson.M{"email": email}			
-	update := bson.M{"$set":bson.M{"token": token}}
-	_, err := coll.UpdateOne(context.TODO(), filter, update)
-	if err != nil{
-		return errors.New("unable to update previous token")
-	}
-	return nil
-}
-
-
-func (user *UserRequest)ToUserIntermediate(isAdmin bool, token string)(*UserIntermediate){
+func (user *UserRequest)ToUserIntermediate(role Role)(*UserIntermediate){
 	return &UserIntermediate{
 		Username: user.Username,
-		Email: user.Email,
+		Email: NormalizeEmail(user.Email),
 		Password: user.Password,
 		Photo: user.Photo,
 		JoinedOn: time.Now(),
-		IsAdmin: isAdmin,
-		LastToken: token,
+		IsAdmin: role == ROLEADMIN,
+		Role: role,
 	}
 }
 
 
 func (user *UserIntermediate) AddUser(coll *mongo.Collection) (*mongo.InsertOneResult, error){
 	// checking if user already exists or not 
+	user.Email = NormalizeEmail(user.Email)
 	var u User
 	filter := bson.M{"email":user.Email}
 	result := coll.FindOne(context.TODO(), filter)
@@ -86,18 +104,23 @@ func (user *UserIntermediate) AddUser(coll *mongo.Collection) (*mongo.InsertOneR
 		return nil, errors.New("User already exists")
 	}	
 
-	return coll.InsertOne(context.TODO(), user)
+	// Same email may have been signed up meanwhile, the index keeps only one
+	inserted, err := coll.InsertOne(context.TODO(), user)
+	if mongo.IsDuplicateKeyError(err){
+		return nil, errors.New("User already exists")
+	}
+	return inserted, err
 }
 
 
-func (user *UserUpdateRequest) UpdateUser(userId, hashedPassword, token string, coll *mongo.Collection) (*mongo.UpdateResult, error){
+func (user *UserUpdateRequest) UpdateUser(userId, hashedPassword string, coll *mongo.Collection) (*mongo.UpdateResult, error){
 	id, err:= primitive.ObjectIDFromHex(userId)
 	if err!=nil{
 		return nil, err
 	}
 	filter := bson.D{{Key: "_id",Value: id}}
 	var update bson.M
-	if hashedPassword == "" || token == ""{
+	if hashedPassword == ""{
 		update = bson.M{
 			"$set":bson.M{
 				"username": user.Username,
@@ -110,20 +133,126 @@ func (user *UserUpdateRequest) UpdateUser(userId, hashedPassword, token string,
 				"username": user.Username,
 				"password" : hashedPassword,
 				"photo" : user.Photo,
-				"token": token,
+				"passwordChangedOn": time.Now(),
 			},
 		}
 	}
 	return coll.UpdateOne(context.TODO(), filter, update)
 } 
 
-func DeleteUser(userId string, pllColl *mongo.Collection) (*mongo.DeleteResult, error){
+// Admin flag is kept in sync for clients still reading it
+func UpdateUserRole(userId string, role Role, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{"_id": id}
+	update := bson.M{
+		"$set": bson.M{
+			"role": role,
+			"isAdmin": role == ROLEADMIN,
+		},
+	}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+func UpdatePassword(userId, hashedPassword string, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{"_id": id}
+	update := bson.M{
+		"$set": bson.M{
+			"password": hashedPassword,
+			"passwordChangedOn": time.Now(),
+		},
+	}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+/*
+Replaces hash of the same password, e.g. with higher bcrypt cost
+Unlike password change, issued tokens stay valid
+Old hash is matched so that a password changed meanwhile isn't overwritten
+*/
+func RehashPassword(userId, oldHashedPassword, hashedPassword string, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{"_id": id, "password": oldHashedPassword}
+	update := bson.M{"$set": bson.M{"password": hashedPassword}}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+func SetUserVerified(userId, email string, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return nil, err
+	}
+	// Email is matched so that verifying an old address does nothing
+	filter := bson.M{"_id": id, "email": email}
+	update := bson.M{"$set": bson.M{"verified": true}}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+/*
+Switches email of the user, new email is verified by the confirmation itself
+Old email is matched so that a stale confirmation does nothing
+*/
+func ChangeUserEmail(userId, oldEmail, newEmail string, coll *mongo.Collection)(*mongo.UpdateResult, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{"_id": id, "email": oldEmail}
+	update := bson.M{"$set": bson.M{"email": NormalizeEmail(newEmail), "verified": true}}
+	return coll.UpdateOne(context.TODO(), filter, update)
+}
+
+/*
+Emails are stored and looked up lowercase, so a mailbox belongs to a single account
+whichever way its address is typed
+*/
+func NormalizeEmail(email string) string{
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+/*
+Lowercases emails stored before they were normalized
+Fails on duplicate key when two accounts only differed in case, they have to be merged by hand
+*/
+func NormalizeLegacyEmails(coll *mongo.Collection) error{
+	filter := bson.M{"email": bson.M{"$regex": `[A-Z]|^\s|\s$`}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"email": bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}}}}}}
+	_, err := coll.UpdateMany(context.TODO(), filter, update)
+	return err
+}
+
+/*
+Users signed up before email verification was introduced are considered verified
+*/
+func MarkLegacyUsersVerified(coll *mongo.Collection) error{
+	filter := bson.M{"verified": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"verified": true}}
+	_, err := coll.UpdateMany(context.TODO(), filter, update)
+	return err
+}
+
+func AdminExists(coll *mongo.Collection)(bool, error){
+	filter := bson.M{"$or": bson.A{bson.M{"role": ROLEADMIN}, bson.M{"isAdmin": true}}}
+	count, err := coll.CountDocuments(context.TODO(), filter)
+	return count > 0, err
+}
+
+func DeleteUser(userId string, userColl *mongo.Collection) (*mongo.DeleteResult, error){
 	id , err:= primitive.ObjectIDFromHex(userId)
 	if err!=nil{
 		return nil, err
 	}
 	filter := bson.M{"_id":id}
-	return pllColl.DeleteOne(context.TODO(), filter)	
+	return userColl.DeleteOne(context.TODO(), filter)	
 }
 
 
@@ -154,14 +283,55 @@ func GetUserById(userId string, coll *mongo.Collection)(*User, error){
 	return &user, nil
 }
 
+/*
+Users added before roles were introduced only have admin flag
+*/
+func (user *User) GetRole() Role{
+	if user.Role != ""{
+		return user.Role
+	}
+	if user.IsAdmin{
+		return ROLEADMIN
+	}
+	return ROLEUSER
+}
+
+/*
+Tokens issued before the last password change are no longer valid
+JWT timestamps only have second precision, so comparison is done in seconds
+*/
+func (user *User) TokenIssuedBeforePasswordChange(issuedOn time.Time) bool{
+	return issuedOn.Before(user.PasswordChangedOn.Truncate(time.Second))
+}
+
+func GetUserByEmail(email string, coll *mongo.Collection)(*User, error){
+	filter := bson.M{"email":NormalizeEmail(email)}
+	var user User
+	if err := coll.FindOne(context.TODO(), filter).Decode(&user); err != nil{
+		return nil, err
+	}
+	return &user, nil
+}
+
+/*
+Returns the users that still exist among @userIds, in no particular order
+Invalid ids are skipped like deleted users
+*/
 func GetUsersById(userIds []string, coll *mongo.Collection) ([]User, error) {
 	users := make([]User, 0)
+	ids := make([]primitive.ObjectID, 0, len(userIds))
 	for _, userId := range userIds{
-		user, err:= GetUserById(userId, coll)
-		if err != nil{
-			continue
+		if id, err := primitive.ObjectIDFromHex(userId); err == nil{
+			ids = append(ids, id)
 		}
-		users = append(users, *user)
 	}
-	return users, nil
+	if len(ids) == 0{
+		return users, nil
+	}
+	cursor, err := coll.Find(context.TODO(), bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil{
+		return users, err
+	}
+	err = cursor.All(context.TODO(), &users)
+	return users, err
 }
\ No newline at end of file
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How a user limits another user
+type RelationKind string
+
+const (
+	// Blocked users can't follow and are not seen by each other
+	RELATIONBLOCK RelationKind = "block"
+	// Muted users are only left out of the feed of the user
+	RELATIONMUTE RelationKind = "mute"
+)
+
+type UserRelation struct{
+	ID string `json:"_id" bson:"_id,omitempty"`
+	UserId primitive.ObjectID `json:"userId" bson:"userId"`
+	TargetId primitive.ObjectID `json:"targetId" bson:"targetId"`
+	Kind RelationKind `json:"kind" bson:"kind"`
+	CreatedOn time.Time `json:"createdOn" bson:"createdOn"`
+}
+
+func AddUserRelation(userId, targetId string, kind RelationKind, coll *mongo.Collection) error{
+	ids, err := toObjectIDs(userId, targetId)
+	if err != nil{
+		return err
+	}
+	filter := bson.M{"userId": ids[0], "targetId": ids[1], "kind": kind}
+	update := bson.M{"$setOnInsert": bson.M{"createdOn": time.Now()}}
+	_, err = coll.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func RemoveUserRelation(userId, targetId string, kind RelationKind, coll *mongo.Collection)(*mongo.DeleteResult, error){
+	ids, err := toObjectIDs(userId, targetId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{"userId": ids[0], "targetId": ids[1], "kind": kind}
+	return coll.DeleteOne(context.TODO(), filter)
+}
+
+// Whether either of the users blocked the other
+func IsBlockedBetween(userId, otherId string, coll *mongo.Collection)(bool, error){
+	ids, err := toObjectIDs(userId, otherId)
+	if err != nil{
+		return false, err
+	}
+	filter := bson.M{
+		"kind": RELATIONBLOCK,
+		"$or": bson.A{
+			bson.M{"userId": ids[0], "targetId": ids[1]},
+			bson.M{"userId": ids[1], "targetId": ids[0]},
+		},
+	}
+	count, err := coll.CountDocuments(context.TODO(), filter, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+/*
+Returns users the user shouldn't see lessons of:
+users they blocked or muted, and users who blocked them
+*/
+func GetExcludedUserIds(userId string, coll *mongo.Collection)([]string, error){
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return nil, err
+	}
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"userId": id},
+			bson.M{"targetId": id, "kind": RELATIONBLOCK},
+		},
+	}
+	cursor, err := coll.Find(context.TODO(), filter)
+	if err != nil{
+		return nil, err
+	}
+	var relations []UserRelation
+	if err := cursor.All(context.TODO(), &relations); err != nil{
+		return nil, err
+	}
+	userIds := make([]string, 0, len(relations))
+	for _, relation := range relations{
+		if relation.UserId == id{
+			userIds = append(userIds, relation.TargetId.Hex())
+		}else{
+			userIds = append(userIds, relation.UserId.Hex())
+		}
+	}
+	return userIds, nil
+}
+
+/*
+Returns @kind relations created by the user, latest first
+*/
+func GetUserRelations(userId string, kind RelationKind, coll *mongo.Collection)([]UserRelation, error){
+	relations := make([]UserRelation, 0)
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil{
+		return relations, err
+	}
+	opts := options.Find().SetSort(bson.M{"createdOn": -1})
+	cursor, err := coll.Find(context.TODO(), bson.M{"userId": id, "kind": kind}, opts)
+	if err != nil{
+		return relations, err
+	}
+	err = cursor.All(context.TODO(), &relations)
+	return relations, err
+}
+
+func EnsureUserRelationIndexes(coll *mongo.Collection) error{
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "targetId", Value: 1}, {Key: "kind", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "targetId", Value: 1}, {Key: "kind", Value: 1}}},
+	}
+	_, err := coll.Indexes().CreateMany(context.TODO(), indexes)
+	return err
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestEmailsAreNormalized(t *testing.T){
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("sign up stores lowercase email", func(mt *mtest.T){
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(),
+		)
+		request := UserRequest{Username: "user", Email: " User@Example.COM ", Password: "hash"}
+		if _, err := request.ToUserIntermediate(ROLEUSER).AddUser(mt.Coll); err != nil{
+			mt.Fatal(err)
+		}
+
+		events := mt.GetAllStartedEvents()
+		if email := events[0].Command.Lookup("filter", "email").StringValue(); email != "user@example.com"{
+			mt.Fatalf("expected existing account looked up by normalized email, got %q", email)
+		}
+		inserted := events[1].Command.Lookup("documents").Array().Index(0).Value().Document()
+		if email := inserted.Lookup("email").StringValue(); email != "user@example.com"{
+			mt.Fatalf("expected normalized email stored, got %q", email)
+		}
+	})
+
+	mt.Run("lookup ignores case", func(mt *mtest.T){
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.Users", mtest.FirstBatch, bson.D{{Key: "email", Value: "user@example.com"}}))
+		if _, err := GetUserByEmail("USER@example.com", mt.Coll); err != nil{
+			mt.Fatal(err)
+		}
+		if email := mt.GetStartedEvent().Command.Lookup("filter", "email").StringValue(); email != "user@example.com"{
+			mt.Fatalf("expected lookup by normalized email, got %q", email)
+		}
+	})
+}